@@ -1,5 +1,7 @@
 package godnn
 
+// ActivationFn derivatives are expressed in terms of the output y = Eval(x).
+
 type ActivationFn interface {
 	Eval(x float32) float32
 	FirstDeriv(y float32) float32
@@ -36,7 +38,7 @@ func (f tanh) Eval(x float32) float32 {
 	exp2x := Exp32(2 * x)
 	return (exp2x - 1) / (exp2x + 1)
 }
-func (f tanh) FirstDeriv(y float32) float32  { return 1 - Sq32(f.Eval(y)) }
-func (f tanh) SecondDeriv(y float32) float32 { return -2 * f.Eval(y) * f.FirstDeriv(y) }
+func (f tanh) FirstDeriv(y float32) float32  { return 1 - Sq32(y) }
+func (f tanh) SecondDeriv(y float32) float32 { return -2 * y * f.FirstDeriv(y) }
 
 var Tanh = new(tanh)
@@ -79,6 +79,41 @@ func (p BlobPoint) SpatialSize() int {
 	return p.Height * p.Width
 }
 
+const (
+	AxisBatch = iota
+	AxisChannel
+	AxisHeight
+	AxisWidth
+	NumAxes
+)
+
+func BlobPointFromShape(shape []int) BlobPoint {
+	return BlobPoint{shape[AxisBatch], shape[AxisChannel], shape[AxisHeight], shape[AxisWidth]}
+}
+
+func (p BlobPoint) Shape() []int {
+	return []int{p.Batch, p.Channel, p.Height, p.Width}
+}
+
+func (p BlobPoint) AxisSize(axis int) int {
+	return p.Shape()[axis]
+}
+
+func (p *BlobPoint) SetAxisSize(axis, size int) {
+	shape := p.Shape()
+	shape[axis] = size
+	*p = BlobPointFromShape(shape)
+}
+
+// Count is the number of values spanned by the axes in [start, end).
+func (p BlobPoint) Count(start, end int) int {
+	count := 1
+	for _, size := range p.Shape()[start:end] {
+		count *= size
+	}
+	return count
+}
+
 func (p BlobPoint) String() string {
 	return fmt.Sprintf("(%d,%d,%d,%d)", p.Batch, p.Channel, p.Height, p.Width)
 }
@@ -88,6 +123,13 @@ type Blob struct {
 	Dim  BlobPoint
 	Data *SyncedData
 	Diff *SyncedData
+	// DiffRows lists the rows (of Dim.Width values) written to a sparse diff,
+	// all other rows are zero. It is nil when the diff is dense.
+	DiffRows []int
+	// LRMult and DecayMult scale the learning rate and weight decay of a
+	// param, see ParamSpec.
+	LRMult    float32
+	DecayMult float32
 }
 
 func (b *Blob) Offset(p *BlobPoint) int {
@@ -109,6 +151,51 @@ func (b *Blob) alloc(dim *BlobPoint) {
 	b.Diff = NewSyncedData(capacity)
 }
 
+// ShareData points the blob data at the data of other, which must be the same size.
+func (b *Blob) ShareData(other *Blob) {
+	b.Data = other.Data
+}
+
+// ShareDiff points the blob diff at the diff of other, which must be the same size.
+func (b *Blob) ShareDiff(other *Blob) {
+	b.Diff = other.Diff
+}
+
+// DataRow returns the values of row i when the blob is viewed as rows of Dim.Width.
+func (b *Blob) DataRow(i int) []float32 {
+	return Subslice32(b.Data.CpuValues(), i, b.Dim.Width)
+}
+
+// DiffRow returns the diff of row i when the blob is viewed as rows of Dim.Width.
+func (b *Blob) DiffRow(i int) []float32 {
+	return Subslice32(b.Diff.CpuValues(), i, b.Dim.Width)
+}
+
+// ClearDiff zeroes the diff, and empties DiffRows when it is sparse.
+func (b *Blob) ClearDiff() {
+	if b.DiffRows == nil {
+		Set32(b.Diff.MutableCpuValues(), 0)
+		return
+	}
+	diff := b.Diff.MutableCpuValues()
+	for _, row := range b.DiffRows {
+		Set32(Subslice32(diff, row, b.Dim.Width), 0)
+	}
+	b.DiffRows = b.DiffRows[:0]
+}
+
+// ScaleDiff multiplies the diff by alpha.
+func (b *Blob) ScaleDiff(alpha float32) {
+	diff := b.Diff.MutableCpuValues()
+	if b.DiffRows == nil {
+		Scal32(len(diff), alpha, diff)
+		return
+	}
+	for _, row := range b.DiffRows {
+		Scal32(b.Dim.Width, alpha, Subslice32(diff, row, b.Dim.Width))
+	}
+}
+
 func (b *Blob) String() string {
 	return fmt.Sprintf("%s: dim=%s", b.Name, b.Dim.String())
 }
@@ -116,6 +203,8 @@ func (b *Blob) String() string {
 func NewBlob(name string, dim *BlobPoint) *Blob {
 	b := new(Blob)
 	b.Name = name
+	b.LRMult = 1
+	b.DecayMult = 1
 	b.alloc(dim)
 	return b
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flammit/godnn"
+)
+
+// csvSource holds the rows of a CSV or TSV file, each a label column and
+// numeric feature columns.
+type csvSource struct {
+	buckets []bucket
+	rows    [][]float32
+	labels  []float32
+	index   int
+}
+
+func (s *csvSource) Buckets() []bucket {
+	return s.buckets
+}
+
+func (s *csvSource) NumItems() int {
+	return len(s.rows)
+}
+
+func (s *csvSource) Next() ([][]float32, error) {
+	values := [][]float32{s.rows[s.index]}
+	if s.labels != nil {
+		values = append(values, []float32{s.labels[s.index]})
+	}
+	s.index++
+	return values, nil
+}
+
+// labelValues converts the labels to numbers, or, if any is not an integer,
+// to the indices of the sorted distinct labels.
+func labelValues(labels []string) []float32 {
+	values := make([]float32, len(labels))
+	numeric := true
+	for i, label := range labels {
+		n, err := strconv.Atoi(label)
+		if err != nil {
+			numeric = false
+			break
+		}
+		values[i] = float32(n)
+	}
+	if numeric {
+		return values
+	}
+
+	classes := make(map[string]int)
+	var names []string
+	for _, label := range labels {
+		if _, ok := classes[label]; !ok {
+			classes[label] = 0
+			names = append(names, label)
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		classes[name] = i
+		log.Printf("Label %d = %s\n", i, name)
+	}
+	for i, label := range labels {
+		values[i] = float32(classes[label])
+	}
+	return values
+}
+
+func runCsv(args []string) error {
+	f := newFlagSet("csv", "<csv or tsv file>")
+	o := addOutputFlags(f)
+	delimiter := f.String("delimiter", "", "column delimiter, by default a tab for .tsv files and a comma otherwise")
+	header := f.Bool("header", false, "skip the first row")
+	labelColumn := f.Int("label-column", 0, "column of the label, -1 for none")
+	dataBucket := f.String("data-bucket", "data", "bucket of the features")
+	labelBucket := f.String("label-bucket", "labels", "bucket of the labels")
+	f.Parse(args)
+	if f.NArg() != 1 {
+		f.Usage()
+		return errors.New("needs one csv file")
+	}
+	fileName := f.Arg(0)
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.Comma = ','
+	if strings.HasSuffix(fileName, ".tsv") {
+		r.Comma = '\t'
+	}
+	if *delimiter != "" {
+		r.Comma = []rune(*delimiter)[0]
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+	if *header && len(records) > 0 {
+		records = records[1:]
+	}
+	if len(records) == 0 {
+		return errors.New("no rows in " + fileName)
+	}
+	if *labelColumn >= len(records[0]) {
+		return errors.New("label column out of range")
+	}
+
+	s := new(csvSource)
+	var labels []string
+	for i, record := range records {
+		row := make([]float32, 0, len(record))
+		for j, field := range record {
+			if j == *labelColumn {
+				labels = append(labels, strings.TrimSpace(field))
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+			if err != nil {
+				return fmt.Errorf("row %d, column %d: %s", i+1, j+1, err)
+			}
+			row = append(row, float32(v))
+		}
+		s.rows = append(s.rows, row)
+	}
+
+	s.buckets = []bucket{{Name: *dataBucket, Type: godnn.StorageFloat32, Dim: godnn.BlobPoint{Batch: 1, Channel: 1, Height: 1, Width: len(s.rows[0])}}}
+	if *labelColumn >= 0 {
+		s.labels = labelValues(labels)
+		s.buckets = append(s.buckets, bucket{Name: *labelBucket, Type: godnn.StorageInt32, Dim: godnn.BlobPoint{Batch: 1, Channel: 1, Height: 1, Width: 1}})
+	}
+	return convert(s, o)
+}
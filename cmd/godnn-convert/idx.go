@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/flammit/godnn"
+)
+
+// idxFile reads the items of an IDX file, the format of the MNIST dataset: a
+// magic number of two zero bytes, the element type and the number of axes,
+// then the big-endian uint32 size of each axis and the big-endian elements.
+type idxFile struct {
+	file     *os.File
+	in       *bufio.Reader
+	bucket   bucket
+	numItems int
+	itemSize int
+	elemType byte
+	p        []byte
+}
+
+func idxElementSize(elemType byte) int {
+	switch elemType {
+	case 0x08, 0x09:
+		return 1
+	case 0x0B:
+		return 2
+	case 0x0C, 0x0D:
+		return 4
+	case 0x0E:
+		return 8
+	}
+	return 0
+}
+
+func openIdx(name, fileName string) (*idxFile, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	in := bufio.NewReader(f)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(in, magic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	elemSize := idxElementSize(magic[2])
+	if magic[0] != 0 || magic[1] != 0 || elemSize == 0 || magic[3] == 0 {
+		f.Close()
+		return nil, errors.New("invalid magic number for idx file " + fileName)
+	}
+
+	shape := make([]int, magic[3])
+	p := make([]byte, 4)
+	for i := range shape {
+		if _, err := io.ReadFull(in, p); err != nil {
+			f.Close()
+			return nil, err
+		}
+		shape[i] = int(binary.BigEndian.Uint32(p))
+	}
+	dim, err := godnn.ItemDim(shape)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	storageType := godnn.StorageInt32
+	switch magic[2] {
+	case 0x08:
+		storageType = godnn.StorageByte
+	case 0x0D, 0x0E:
+		storageType = godnn.StorageFloat32
+	}
+	return &idxFile{
+		file:     f,
+		in:       in,
+		bucket:   bucket{Name: name, Type: storageType, Dim: dim},
+		numItems: shape[0],
+		itemSize: dim.Size(),
+		elemType: magic[2],
+		p:        make([]byte, dim.Size()*elemSize),
+	}, nil
+}
+
+func (f *idxFile) next() ([]float32, error) {
+	if _, err := io.ReadFull(f.in, f.p); err != nil {
+		return nil, err
+	}
+	values := make([]float32, f.itemSize)
+	for i := range values {
+		switch f.elemType {
+		case 0x08:
+			values[i] = float32(f.p[i])
+		case 0x09:
+			values[i] = float32(int8(f.p[i]))
+		case 0x0B:
+			values[i] = float32(int16(binary.BigEndian.Uint16(f.p[2*i:])))
+		case 0x0C:
+			values[i] = float32(int32(binary.BigEndian.Uint32(f.p[4*i:])))
+		case 0x0D:
+			values[i] = math.Float32frombits(binary.BigEndian.Uint32(f.p[4*i:]))
+		case 0x0E:
+			values[i] = float32(math.Float64frombits(binary.BigEndian.Uint64(f.p[8*i:])))
+		}
+	}
+	return values, nil
+}
+
+// idxSource reads one IDX file per bucket.
+type idxSource struct {
+	files []*idxFile
+}
+
+func (s *idxSource) Buckets() []bucket {
+	buckets := make([]bucket, len(s.files))
+	for i, f := range s.files {
+		buckets[i] = f.bucket
+	}
+	return buckets
+}
+
+func (s *idxSource) NumItems() int {
+	return s.files[0].numItems
+}
+
+func (s *idxSource) Next() ([][]float32, error) {
+	values := make([][]float32, len(s.files))
+	for i, f := range s.files {
+		var err error
+		values[i], err = f.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (s *idxSource) close() {
+	for _, f := range s.files {
+		f.file.Close()
+	}
+}
+
+// splitBucketArgs splits <bucket>=<file> arguments.
+func splitBucketArgs(args []string) ([]string, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, errors.New("needs <bucket>=<file> arguments")
+	}
+	names := make([]string, len(args))
+	fileNames := make([]string, len(args))
+	for i, arg := range args {
+		j := strings.Index(arg, "=")
+		if j <= 0 {
+			return nil, nil, errors.New("invalid argument " + arg + ", needs <bucket>=<file>")
+		}
+		names[i], fileNames[i] = arg[:j], arg[j+1:]
+	}
+	return names, fileNames, nil
+}
+
+func runIdx(args []string) error {
+	f := newFlagSet("idx", "<bucket>=<idx file> ...")
+	o := addOutputFlags(f)
+	f.Parse(args)
+	names, fileNames, err := splitBucketArgs(f.Args())
+	if err != nil {
+		return err
+	}
+
+	s := new(idxSource)
+	defer s.close()
+	for i, name := range names {
+		file, err := openIdx(name, fileNames[i])
+		if err != nil {
+			return err
+		}
+		s.files = append(s.files, file)
+		if file.numItems != s.files[0].numItems {
+			return errors.New("each idx file needs the same number of items")
+		}
+	}
+	return convert(s, o)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flammit/godnn"
+)
+
+// imageSource reads a directory with one folder of images per class, labeled
+// by the index of the folder in name order.
+type imageSource struct {
+	buckets   []bucket
+	fileNames []string
+	labels    []float32
+	index     int
+}
+
+func (s *imageSource) Buckets() []bucket {
+	return s.buckets
+}
+
+func (s *imageSource) NumItems() int {
+	return len(s.fileNames)
+}
+
+func decodeImageFile(fileName string) (image.Image, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, errors.New(fileName + ": " + err.Error())
+	}
+	return img, nil
+}
+
+func (s *imageSource) Next() ([][]float32, error) {
+	img, err := decodeImageFile(s.fileNames[s.index])
+	if err != nil {
+		return nil, err
+	}
+	dim := s.buckets[0].Dim
+	values := make([]float32, dim.Size())
+	err = godnn.ImageToValues(img, &dim, values)
+	if err != nil {
+		return nil, err
+	}
+	label := s.labels[s.index]
+	s.index++
+	return [][]float32{values, {label}}, nil
+}
+
+func isImageFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+func runImages(args []string) error {
+	f := newFlagSet("images", "<directory of class folders>")
+	o := addOutputFlags(f)
+	width := f.Int("width", 0, "width to resize images to, by default that of the first image")
+	height := f.Int("height", 0, "height to resize images to, by default that of the first image")
+	channels := f.Int("channels", 3, "1 for gray, 3 for RGB or 4 for RGBA")
+	storageType := f.String("type", godnn.StoragePng, "storage type of the images: png, jpeg or byte")
+	imageBucket := f.String("image-bucket", "images", "bucket of the images")
+	labelBucket := f.String("label-bucket", "labels", "bucket of the labels")
+	f.Parse(args)
+	if f.NArg() != 1 {
+		f.Usage()
+		return errors.New("needs one directory")
+	}
+	switch *storageType {
+	case godnn.StoragePng, godnn.StorageJpeg, godnn.StorageByte:
+	default:
+		return errors.New("invalid image storage type " + *storageType)
+	}
+
+	dir := f.Arg(0)
+	classes, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	s := new(imageSource)
+	label := 0
+	for _, class := range classes {
+		if !class.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(dir, class.Name()))
+		if err != nil {
+			return err
+		}
+		log.Printf("Label %d = %s\n", label, class.Name())
+		for _, file := range files {
+			if file.IsDir() || !isImageFile(file.Name()) {
+				continue
+			}
+			s.fileNames = append(s.fileNames, filepath.Join(dir, class.Name(), file.Name()))
+			s.labels = append(s.labels, float32(label))
+		}
+		label++
+	}
+	if len(s.fileNames) == 0 {
+		return errors.New("no images in the class folders of " + dir)
+	}
+
+	if *width == 0 || *height == 0 {
+		img, err := decodeImageFile(s.fileNames[0])
+		if err != nil {
+			return err
+		}
+		if *width == 0 {
+			*width = img.Bounds().Dx()
+		}
+		if *height == 0 {
+			*height = img.Bounds().Dy()
+		}
+	}
+	s.buckets = []bucket{
+		{Name: *imageBucket, Type: *storageType, Dim: godnn.BlobPoint{Batch: 1, Channel: *channels, Height: *height, Width: *width}},
+		{Name: *labelBucket, Type: godnn.StorageInt32, Dim: godnn.BlobPoint{Batch: 1, Channel: 1, Height: 1, Width: 1}},
+	}
+	return convert(s, o)
+}
@@ -0,0 +1,71 @@
+// Command godnn-convert converts datasets into the BoltDB format read by
+// godnn.BoltDbDataLayer, and prints the buckets of converted databases.
+//
+// Usage:
+//
+//	godnn-convert idx [flags] <bucket>=<idx file> ...
+//	godnn-convert csv [flags] <csv or tsv file>
+//	godnn-convert images [flags] <directory of class folders>
+//	godnn-convert npy [flags] <bucket>=<npy file> ...
+//	godnn-convert npz [flags] <npz file> [array ...]
+//	godnn-convert stats <db file> ...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"idx", "<bucket>=<idx file> ...", runIdx},
+	{"csv", "<csv or tsv file>", runCsv},
+	{"images", "<directory of class folders>", runImages},
+	{"npy", "<bucket>=<npy file> ...", runNpy},
+	{"npz", "<npz file> [array ...]", runNpz},
+	{"stats", "<db file> ...", runStats},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: godnn-convert <command> [flags] <args>")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "    godnn-convert %s [flags] %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "Run godnn-convert <command> -h for the flags of a command.")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		err := c.run(os.Args[2:])
+		if err != nil {
+			log.Fatalf("godnn-convert %s: %s\n", c.name, err)
+		}
+		return
+	}
+	usage()
+}
+
+// newFlagSet returns the flags of a command, with usage naming its arguments.
+func newFlagSet(name, args string) *flag.FlagSet {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	f.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: godnn-convert %s [flags] %s\n", name, args)
+		f.PrintDefaults()
+	}
+	return f
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/flammit/godnn"
+)
+
+// arraySource holds one NumPy array per bucket, split into items along the
+// first axis.
+type arraySource struct {
+	buckets  []bucket
+	arrays   [][]float32
+	numItems int
+	index    int
+}
+
+func (s *arraySource) Buckets() []bucket {
+	return s.buckets
+}
+
+func (s *arraySource) NumItems() int {
+	return s.numItems
+}
+
+func (s *arraySource) Next() ([][]float32, error) {
+	values := make([][]float32, len(s.arrays))
+	for i, array := range s.arrays {
+		size := s.buckets[i].Dim.Size()
+		values[i] = array[s.index*size : (s.index+1)*size]
+	}
+	s.index++
+	return values, nil
+}
+
+// add adds the array of h as a bucket, stored as float32 for floats, bytes
+// for bools and uint8 and int32 otherwise.
+func (s *arraySource) add(name string, h *godnn.NpyHeader, array []float32) error {
+	if len(h.Shape) == 0 {
+		return errors.New("array " + name + " has no items")
+	}
+	dim, err := h.ItemDim()
+	if err != nil {
+		return err
+	}
+	if len(s.buckets) > 0 && h.Shape[0] != s.numItems {
+		return errors.New("each array needs the same number of items")
+	}
+	s.numItems = h.Shape[0]
+
+	storageType := godnn.StorageInt32
+	switch h.Dtype[1:] {
+	case "b1", "u1":
+		storageType = godnn.StorageByte
+	case "f4", "f8":
+		storageType = godnn.StorageFloat32
+	}
+	s.buckets = append(s.buckets, bucket{Name: name, Type: storageType, Dim: dim})
+	s.arrays = append(s.arrays, array)
+	return nil
+}
+
+func runNpy(args []string) error {
+	f := newFlagSet("npy", "<bucket>=<npy file> ...")
+	o := addOutputFlags(f)
+	f.Parse(args)
+	names, fileNames, err := splitBucketArgs(f.Args())
+	if err != nil {
+		return err
+	}
+
+	s := new(arraySource)
+	for i, name := range names {
+		file, err := os.Open(fileNames[i])
+		if err != nil {
+			return err
+		}
+		h, array, err := godnn.ReadNpy(file)
+		file.Close()
+		if err != nil {
+			return errors.New(fileNames[i] + ": " + err.Error())
+		}
+		err = s.add(name, h, array)
+		if err != nil {
+			return err
+		}
+	}
+	return convert(s, o)
+}
+
+func runNpz(args []string) error {
+	f := newFlagSet("npz", "<npz file> [array ...]")
+	o := addOutputFlags(f)
+	f.Parse(args)
+	if f.NArg() < 1 {
+		f.Usage()
+		return errors.New("needs an npz file")
+	}
+	fileName := f.Arg(0)
+	names := f.Args()[1:]
+	if len(names) == 0 {
+		var err error
+		names, err = godnn.NpzArrayNames(fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	s := new(arraySource)
+	for _, name := range names {
+		h, array, err := godnn.ReadNpz(fileName, name)
+		if err != nil {
+			return errors.New(name + ": " + err.Error())
+		}
+		err = s.add(name, h, array)
+		if err != nil {
+			return err
+		}
+	}
+	return convert(s, o)
+}
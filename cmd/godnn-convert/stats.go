@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+func dimValue(b *bolt.Bucket, key string) int {
+	p := b.Get([]byte(key))
+	if len(p) != 4 {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(p))
+}
+
+// printStats prints the type, shape and number of items of every bucket of a
+// database, flagging buckets whose item count differs from their dimensions.
+func printStats(fileName string) error {
+	db, err := bolt.Open(fileName, 0400, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Println(fileName)
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			bucketName := string(name)
+			if strings.HasSuffix(bucketName, "_dim") {
+				return nil
+			}
+			numKeys := b.Stats().KeyN
+			dimBucket := tx.Bucket([]byte(bucketName + "_dim"))
+			if dimBucket == nil {
+				fmt.Printf("    %s: %d keys, no dimensions\n", bucketName, numKeys)
+				return nil
+			}
+
+			storageType := string(dimBucket.Get([]byte("type")))
+			if storageType == "" {
+				storageType = "byte"
+			}
+			num := dimValue(dimBucket, "num")
+			fmt.Printf("    %s: %s, %d x (%d, %d, %d), %d keys", bucketName, storageType, num,
+				dimValue(dimBucket, "channel"), dimValue(dimBucket, "height"), dimValue(dimBucket, "width"), numKeys)
+			if num != numKeys {
+				fmt.Print(" (item count mismatch)")
+			}
+			fmt.Println()
+			return nil
+		})
+	})
+}
+
+func runStats(args []string) error {
+	f := newFlagSet("stats", "<db file> ...")
+	f.Parse(args)
+	if f.NArg() == 0 {
+		f.Usage()
+		return errors.New("needs a db file")
+	}
+	for _, fileName := range f.Args() {
+		err := printStats(fileName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
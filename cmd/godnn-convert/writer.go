@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"log"
+	"math"
+	"math/rand"
+
+	"github.com/boltdb/bolt"
+	"github.com/flammit/godnn"
+)
+
+// bucket is a bucket of a converted dataset, holding one value of Dim, which
+// has a batch of 1, per item.
+type bucket struct {
+	Name string
+	Type string
+	Dim  godnn.BlobPoint
+}
+
+// source is a dataset to convert, read an item at a time.
+type source interface {
+	Buckets() []bucket
+	NumItems() int
+	// Next reads the next item, with one value per bucket.
+	Next() ([][]float32, error)
+}
+
+// output holds the flags shared by the converting commands.
+type output struct {
+	fileName    string
+	valFileName string
+	valFraction float64
+	seed        int64
+	batchSize   int
+	progress    int
+}
+
+func addOutputFlags(f *flag.FlagSet) *output {
+	o := new(output)
+	f.StringVar(&o.fileName, "out", "train.db", "database to write")
+	f.StringVar(&o.valFileName, "val-out", "val.db", "database to write the validation split to")
+	f.Float64Var(&o.valFraction, "val", 0, "fraction of the items to split off for validation")
+	f.Int64Var(&o.seed, "seed", godnn.DefaultSeed, "seed of the random validation split")
+	f.IntVar(&o.batchSize, "batch", 1000, "items written per transaction")
+	f.IntVar(&o.progress, "progress", 10000, "items between progress reports, 0 to disable")
+	return o
+}
+
+// dataset is an output database being written in batches.
+type dataset struct {
+	db      *bolt.DB
+	index   int
+	pending [][][]byte
+}
+
+func putUint32(n int) []byte {
+	p := make([]byte, 4)
+	binary.LittleEndian.PutUint32(p, uint32(n))
+	return p
+}
+
+// createDataset creates the buckets of a dataset. Their number of items is
+// only written by finish, so a dataset left unfinished does not open.
+func createDataset(fileName string, buckets []bucket) (*dataset, error) {
+	db, err := bolt.Open(fileName, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			_, err := tx.CreateBucket([]byte(b.Name))
+			if err != nil {
+				return errors.New("bucket " + b.Name + ": " + err.Error())
+			}
+			dimBucket, err := tx.CreateBucket([]byte(b.Name + "_dim"))
+			if err != nil {
+				return errors.New("bucket " + b.Name + "_dim: " + err.Error())
+			}
+			dims := []struct {
+				key   string
+				value []byte
+			}{
+				{"type", []byte(b.Type)},
+				{"channel", putUint32(b.Dim.Channel)},
+				{"height", putUint32(b.Dim.Height)},
+				{"width", putUint32(b.Dim.Width)},
+			}
+			for _, dim := range dims {
+				err = dimBucket.Put([]byte(dim.key), dim.value)
+				if err != nil {
+					return errors.New("bucket " + b.Name + "_dim: " + err.Error())
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &dataset{db: db}, nil
+}
+
+// flush writes the pending items in one transaction.
+func (d *dataset) flush(buckets []bucket) error {
+	if len(d.pending) == 0 {
+		return nil
+	}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		for _, values := range d.pending {
+			key := putUint32(d.index)
+			for i, b := range buckets {
+				err := tx.Bucket([]byte(b.Name)).Put(key, values[i])
+				if err != nil {
+					return err
+				}
+			}
+			d.index++
+		}
+		return nil
+	})
+	d.pending = d.pending[:0]
+	return err
+}
+
+// finish writes the pending items and then the number of items written.
+func (d *dataset) finish(buckets []bucket) error {
+	err := d.flush(buckets)
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			err := tx.Bucket([]byte(b.Name+"_dim")).Put([]byte("num"), putUint32(d.index))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// convert writes every item of src to the output database, or to the
+// validation database for a random valFraction of the items.
+func convert(src source, o *output) error {
+	buckets := src.Buckets()
+	numItems := src.NumItems()
+	if numItems == 0 {
+		return errors.New("no items to convert")
+	}
+	if o.batchSize <= 0 {
+		o.batchSize = 1
+	}
+	if o.valFraction < 0 || o.valFraction >= 1 {
+		return errors.New("validation fraction needs to be in [0, 1)")
+	}
+
+	numVal := int(math.Floor(o.valFraction*float64(numItems) + 0.5))
+	isVal := make([]bool, numItems)
+	for _, i := range rand.New(rand.NewSource(o.seed)).Perm(numItems)[:numVal] {
+		isVal[i] = true
+	}
+
+	train, err := createDataset(o.fileName, buckets)
+	if err != nil {
+		return err
+	}
+	defer train.db.Close()
+	datasets := []*dataset{train}
+	val := train
+	if numVal > 0 {
+		val, err = createDataset(o.valFileName, buckets)
+		if err != nil {
+			return err
+		}
+		defer val.db.Close()
+		datasets = append(datasets, val)
+	}
+
+	if numVal > 0 {
+		log.Printf("Converting %d items into %s (%d) and %s (%d)\n", numItems, o.fileName, numItems-numVal, o.valFileName, numVal)
+	} else {
+		log.Printf("Converting %d items into %s\n", numItems, o.fileName)
+	}
+	for i := 0; i < numItems; i++ {
+		values, err := src.Next()
+		if err != nil {
+			return err
+		}
+		encoded := make([][]byte, len(buckets))
+		for j, b := range buckets {
+			dim := b.Dim
+			if len(values[j]) != dim.Size() {
+				return errors.New("item does not match the dimensions of bucket " + b.Name)
+			}
+			encoded[j], err = godnn.EncodeValue(b.Type, values[j], &dim)
+			if err != nil {
+				return err
+			}
+		}
+
+		d := train
+		if isVal[i] {
+			d = val
+		}
+		d.pending = append(d.pending, encoded)
+		if len(d.pending) >= o.batchSize {
+			err = d.flush(buckets)
+			if err != nil {
+				return err
+			}
+		}
+		if o.progress > 0 && (i+1)%o.progress == 0 {
+			log.Printf("Converted %d/%d items\n", i+1, numItems)
+		}
+	}
+
+	for _, d := range datasets {
+		err = d.finish(buckets)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Converted %d items\n", numItems)
+	return nil
+}
@@ -0,0 +1,43 @@
+package eval
+
+import (
+	"math"
+	"sort"
+)
+
+// RocAuc is the area under the ROC curve of scores for separating positive
+// from negative samples. Tied scores count half. It is NaN when either class
+// has no samples.
+func RocAuc(scores []float32, positive []bool) float32 {
+	indices := make([]int, len(scores))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.Slice(indices, func(a, b int) bool { return scores[indices[a]] < scores[indices[b]] })
+
+	// Mann-Whitney U statistic from the rank sum of the positives, with tied
+	// scores sharing their average rank
+	numPositive := 0
+	rankSum := float64(0)
+	for start := 0; start < len(indices); {
+		end := start + 1
+		for end < len(indices) && scores[indices[end]] == scores[indices[start]] {
+			end++
+		}
+		rank := float64(start+end+1) / 2
+		for _, i := range indices[start:end] {
+			if positive[i] {
+				numPositive++
+				rankSum += rank
+			}
+		}
+		start = end
+	}
+
+	numNegative := len(scores) - numPositive
+	if numPositive == 0 || numNegative == 0 {
+		return float32(math.NaN())
+	}
+	u := rankSum - float64(numPositive*(numPositive+1))/2
+	return float32(u / float64(numPositive) / float64(numNegative))
+}
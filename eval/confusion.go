@@ -0,0 +1,112 @@
+package eval
+
+// ConfusionMatrix counts predictions by class: Counts[actual][predicted].
+type ConfusionMatrix struct {
+	NumClasses int
+	Counts     [][]int
+}
+
+func NewConfusionMatrix(numClasses int) *ConfusionMatrix {
+	m := &ConfusionMatrix{NumClasses: numClasses}
+	m.Counts = make([][]int, numClasses)
+	for i := range m.Counts {
+		m.Counts[i] = make([]int, numClasses)
+	}
+	return m
+}
+
+func (m *ConfusionMatrix) Add(actual, predicted int) {
+	m.Counts[actual][predicted]++
+}
+
+// Merge adds the counts of another matrix with the same number of classes.
+func (m *ConfusionMatrix) Merge(other *ConfusionMatrix) {
+	for i, row := range other.Counts {
+		for j, count := range row {
+			m.Counts[i][j] += count
+		}
+	}
+}
+
+func (m *ConfusionMatrix) Total() int {
+	total := 0
+	for _, row := range m.Counts {
+		for _, count := range row {
+			total += count
+		}
+	}
+	return total
+}
+
+func (m *ConfusionMatrix) TruePositives(class int) int {
+	return m.Counts[class][class]
+}
+
+func (m *ConfusionMatrix) FalsePositives(class int) int {
+	count := 0
+	for i := range m.Counts {
+		if i != class {
+			count += m.Counts[i][class]
+		}
+	}
+	return count
+}
+
+func (m *ConfusionMatrix) FalseNegatives(class int) int {
+	count := 0
+	for j, c := range m.Counts[class] {
+		if j != class {
+			count += c
+		}
+	}
+	return count
+}
+
+// Support is the number of samples whose actual class is class.
+func (m *ConfusionMatrix) Support(class int) int {
+	count := 0
+	for _, c := range m.Counts[class] {
+		count += c
+	}
+	return count
+}
+
+func (m *ConfusionMatrix) Accuracy() float32 {
+	correct := 0
+	for i := range m.Counts {
+		correct += m.Counts[i][i]
+	}
+	return ratio(correct, m.Total())
+}
+
+func (m *ConfusionMatrix) Precision(class int) float32 {
+	tp := m.TruePositives(class)
+	return ratio(tp, tp+m.FalsePositives(class))
+}
+
+func (m *ConfusionMatrix) Recall(class int) float32 {
+	tp := m.TruePositives(class)
+	return ratio(tp, tp+m.FalseNegatives(class))
+}
+
+func (m *ConfusionMatrix) F1(class int) float32 {
+	precision, recall := m.Precision(class), m.Recall(class)
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
+
+// IoU is the intersection over union of the predicted and actual regions of
+// a class, as used for segmentation.
+func (m *ConfusionMatrix) IoU(class int) float32 {
+	tp := m.TruePositives(class)
+	return ratio(tp, tp+m.FalsePositives(class)+m.FalseNegatives(class))
+}
+
+func ratio(n, d int) float32 {
+	if d == 0 {
+		return 0
+	}
+	return float32(n) / float32(d)
+}
@@ -0,0 +1,209 @@
+// Package eval measures how well a network classifies the inputs of its data
+// layer.
+package eval
+
+import (
+	"errors"
+	"math"
+
+	"github.com/flammit/godnn"
+)
+
+var (
+	ErrNoDataLayer   = errors.New("invalid network: no data layer")
+	ErrUnknownBlob   = errors.New("invalid config: no blob with that name")
+	ErrInvalidLabels = errors.New("invalid label blob: need one label per spatial position")
+	ErrInvalidLabel  = errors.New("invalid label: not a class of the score blob")
+)
+
+// Config names the blobs to evaluate. The score blob holds one score per
+// class along the channel axis, at every spatial position, and the label blob
+// one label per spatial position. Scores should be probabilities, such as the
+// prob top of a SoftmaxWithLossLayer, for the ROC-AUC to compare classes.
+type Config struct {
+	ScoreBlobName  string
+	LabelBlobName  string
+	HasIgnoreLabel bool
+	IgnoreLabel    int
+	// SkipRocAuc avoids keeping every score, which is costly for
+	// segmentation networks.
+	SkipRocAuc bool
+}
+
+// Report holds metrics over a full pass through the inputs of a data layer.
+// Per class metrics are indexed by class, RocAuc is one class against the
+// rest and is NaN for classes without positive or negative samples.
+type Report struct {
+	NumBatches     int
+	NumSamples     int
+	Confusion      *ConfusionMatrix
+	Accuracy       float32
+	Precision      []float32
+	Recall         []float32
+	F1             []float32
+	IoU            []float32
+	RocAuc         []float32
+	MacroPrecision float32
+	MacroRecall    float32
+	MacroF1        float32
+	MeanIoU        float32
+	MacroRocAuc    float32
+	// Losses is the mean of each network loss over the batches.
+	Losses map[string]float32
+}
+
+// Evaluate rewinds the first data layer of the network to the start of its
+// epoch and runs the network forward until the epoch ends, covering every
+// input, and reports metrics aggregated over all batches. Batch items that do
+// not belong to the epoch, such as padding, are not counted.
+func Evaluate(net *godnn.Network, c Config) (*Report, error) {
+	var dataLayer godnn.DataLayer
+	for _, layer := range net.Layers {
+		if l, ok := layer.(godnn.DataLayer); ok {
+			dataLayer = l
+			break
+		}
+	}
+	if dataLayer == nil {
+		return nil, ErrNoDataLayer
+	}
+	scores, labels := net.BlobsByName[c.ScoreBlobName], net.BlobsByName[c.LabelBlobName]
+	if scores == nil || labels == nil {
+		return nil, ErrUnknownBlob
+	}
+
+	numBatches := scores.Dim.Batch
+	numClasses := scores.Dim.Channel
+	spatialSize := scores.Dim.SpatialSize()
+	if labels.Dim.Size() != numBatches*spatialSize {
+		return nil, ErrInvalidLabels
+	}
+
+	r := &Report{
+		Confusion: NewConfusionMatrix(numClasses),
+		Losses:    make(map[string]float32),
+	}
+	var classScores [][]float32
+	var actual []int
+	if !c.SkipRocAuc {
+		classScores = make([][]float32, numClasses)
+	}
+
+	dataLayer.Reset()
+	for epochEnded := false; !epochEnded; {
+		net.Forward()
+		if err := dataLayer.Err(); err != nil {
+			return nil, err
+		}
+		epochEnded = dataLayer.EpochEnded()
+		used := minInt(dataLayer.NumInEpoch(), numBatches)
+		if used == 0 {
+			continue
+		}
+
+		r.NumBatches++
+		for name, loss := range net.LossesByName() {
+			r.Losses[name] += loss
+		}
+
+		scoreData := scores.Data.CpuValues()
+		labelData := labels.Data.CpuValues()
+		for n := 0; n < used; n++ {
+			scoreSlice := godnn.Subslice32(scoreData, n, numClasses*spatialSize)
+			for s := 0; s < spatialSize; s++ {
+				label := int(labelData[n*spatialSize+s])
+				if c.HasIgnoreLabel && label == c.IgnoreLabel {
+					continue
+				}
+				if label < 0 || label >= numClasses {
+					return nil, ErrInvalidLabel
+				}
+
+				predicted := 0
+				for k := 1; k < numClasses; k++ {
+					if scoreSlice[k*spatialSize+s] > scoreSlice[predicted*spatialSize+s] {
+						predicted = k
+					}
+				}
+				r.Confusion.Add(label, predicted)
+				r.NumSamples++
+
+				if classScores != nil {
+					for k := range classScores {
+						classScores[k] = append(classScores[k], scoreSlice[k*spatialSize+s])
+					}
+					actual = append(actual, label)
+				}
+			}
+		}
+	}
+
+	for name := range r.Losses {
+		r.Losses[name] /= float32(r.NumBatches)
+	}
+	r.summarize(classScores, actual)
+	return r, nil
+}
+
+func (r *Report) summarize(classScores [][]float32, actual []int) {
+	m := r.Confusion
+	r.Accuracy = m.Accuracy()
+	r.Precision = make([]float32, m.NumClasses)
+	r.Recall = make([]float32, m.NumClasses)
+	r.F1 = make([]float32, m.NumClasses)
+	r.IoU = make([]float32, m.NumClasses)
+	for k := 0; k < m.NumClasses; k++ {
+		r.Precision[k] = m.Precision(k)
+		r.Recall[k] = m.Recall(k)
+		r.F1[k] = m.F1(k)
+		r.IoU[k] = m.IoU(k)
+	}
+	r.MacroPrecision = mean(r.Precision)
+	r.MacroRecall = mean(r.Recall)
+	r.MacroF1 = mean(r.F1)
+
+	// classes neither present nor predicted do not count towards the mean IoU
+	presentIoU := make([]float32, 0, m.NumClasses)
+	for k, iou := range r.IoU {
+		if m.Support(k)+m.FalsePositives(k) > 0 {
+			presentIoU = append(presentIoU, iou)
+		}
+	}
+	r.MeanIoU = mean(presentIoU)
+
+	r.MacroRocAuc = float32(math.NaN())
+	if classScores == nil {
+		return
+	}
+	r.RocAuc = make([]float32, m.NumClasses)
+	positive := make([]bool, len(actual))
+	for k := range r.RocAuc {
+		for i, label := range actual {
+			positive[i] = label == k
+		}
+		r.RocAuc[k] = RocAuc(classScores[k], positive)
+	}
+	r.MacroRocAuc = mean(r.RocAuc)
+}
+
+// mean averages the values that are not NaN.
+func mean(values []float32) float32 {
+	sum, count := float32(0), 0
+	for _, v := range values {
+		if v == v {
+			sum += v
+			count++
+		}
+	}
+	if count == 0 {
+		return float32(math.NaN())
+	}
+	return sum / float32(count)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+package godnn
+
+// Filler initializes a param. fanIn and fanOut are the number of inputs to
+// and outputs from each unit, which the variance scaling fillers use.
+type Filler interface {
+	Fill(b *Blob, fanIn, fanOut int, r *RandomSource)
+}
+
+// fillParam fills a newly created param with filler, or defaultFiller when
+// the layer has none configured, drawing from the layer's random source.
+func fillParam(d *LayerData, b *Blob, filler, defaultFiller Filler, fanIn, fanOut int) {
+	if filler == nil {
+		filler = defaultFiller
+	}
+	filler.Fill(b, fanIn, fanOut, d.RandomSource())
+}
+
+// VarianceNorm selects the fan the variance scaling fillers divide by.
+type VarianceNorm int
+
+const (
+	VarianceNormFanIn VarianceNorm = iota
+	VarianceNormFanOut
+	VarianceNormAverage
+)
+
+func (v VarianceNorm) fan(fanIn, fanOut int) float32 {
+	switch v {
+	case VarianceNormFanOut:
+		return float32(fanOut)
+	case VarianceNormAverage:
+		return float32(fanIn+fanOut) / 2
+	}
+	return float32(fanIn)
+}
+
+type ConstantFiller struct {
+	Value float32
+}
+
+var _ = Filler(new(ConstantFiller))
+
+func (f *ConstantFiller) Fill(b *Blob, fanIn, fanOut int, r *RandomSource) {
+	Set32(b.Data.MutableCpuValues(), f.Value)
+}
+
+type UniformFiller struct {
+	Min float32
+	Max float32
+}
+
+var _ = Filler(new(UniformFiller))
+
+func (f *UniformFiller) Fill(b *Blob, fanIn, fanOut int, r *RandomSource) {
+	fillUniform(b.Data.MutableCpuValues(), f.Min, f.Max, r)
+}
+
+type GaussianFiller struct {
+	Mean float32
+	Std  float32
+}
+
+var _ = Filler(new(GaussianFiller))
+
+func (f *GaussianFiller) Fill(b *Blob, fanIn, fanOut int, r *RandomSource) {
+	fillGaussian(b.Data.MutableCpuValues(), f.Mean, f.Std, r)
+}
+
+// XavierFiller (Glorot) fills uniformly in [-sqrt(3/n), sqrt(3/n)], n being
+// the fan selected by Norm, which keeps the variance of activations constant
+// across linear layers.
+type XavierFiller struct {
+	Norm VarianceNorm
+}
+
+var _ = Filler(new(XavierFiller))
+
+func (f *XavierFiller) Fill(b *Blob, fanIn, fanOut int, r *RandomSource) {
+	scale := Sqrt32(3 / f.Norm.fan(fanIn, fanOut))
+	fillUniform(b.Data.MutableCpuValues(), -scale, scale, r)
+}
+
+// MSRAFiller (He) fills from a Gaussian with standard deviation sqrt(2/n), n
+// being the fan selected by Norm, which suits layers followed by ReLUs.
+type MSRAFiller struct {
+	Norm VarianceNorm
+}
+
+var _ = Filler(new(MSRAFiller))
+
+func (f *MSRAFiller) Fill(b *Blob, fanIn, fanOut int, r *RandomSource) {
+	fillGaussian(b.Data.MutableCpuValues(), 0, Sqrt32(2/f.Norm.fan(fanIn, fanOut)), r)
+}
+
+// OrthogonalFiller fills the param, seen as a matrix with fanIn columns, with
+// orthonormal rows (or columns, when there are more rows than columns) scaled
+// by Gain. A Gain of 0 defaults to 1.
+type OrthogonalFiller struct {
+	Gain float32
+}
+
+var _ = Filler(new(OrthogonalFiller))
+
+func (f *OrthogonalFiller) Fill(b *Blob, fanIn, fanOut int, r *RandomSource) {
+	data := b.Data.MutableCpuValues()
+	cols := fanIn
+	rows := len(data) / cols
+	gain := f.Gain
+	if gain == 0 {
+		gain = 1
+	}
+
+	// orthonormalize the vectors along the shorter side of a Gaussian matrix
+	// with Gram-Schmidt
+	numVectors, vectorSize := rows, cols
+	at := func(v, i int) int { return v*cols + i }
+	if rows > cols {
+		numVectors, vectorSize = cols, rows
+		at = func(v, i int) int { return i*cols + v }
+	}
+	fillGaussian(data, 0, 1, r)
+	for v := 0; v < numVectors; v++ {
+		for u := 0; u < v; u++ {
+			dot := float32(0)
+			for i := 0; i < vectorSize; i++ {
+				dot += data[at(v, i)] * data[at(u, i)]
+			}
+			for i := 0; i < vectorSize; i++ {
+				data[at(v, i)] -= dot * data[at(u, i)]
+			}
+		}
+		norm := float32(0)
+		for i := 0; i < vectorSize; i++ {
+			norm += Sq32(data[at(v, i)])
+		}
+		norm = Sqrt32(norm)
+		for i := 0; i < vectorSize; i++ {
+			data[at(v, i)] /= norm
+		}
+	}
+	Scal32(len(data), gain, data)
+}
+
+// BilinearFiller fills every (Height x Width) kernel with bilinear
+// interpolation weights, so that a deconvolution with a stride of s and a
+// kernel of 2s - s%2 upsamples by s.
+type BilinearFiller struct{}
+
+var _ = Filler(new(BilinearFiller))
+
+func (f *BilinearFiller) Fill(b *Blob, fanIn, fanOut int, r *RandomSource) {
+	data := b.Data.MutableCpuValues()
+	height, width := b.Dim.Height, b.Dim.Width
+	factor := Ceil32(float32(width) / 2)
+	center := (2*factor - 1 - float32(int(factor)%2)) / (2 * factor)
+	for i := range data {
+		x := float32(i % width)
+		y := float32((i / width) % height)
+		data[i] = (1 - Abs32(x/factor-center)) * (1 - Abs32(y/factor-center))
+	}
+}
+
+func fillUniform(data []float32, min, max float32, r *RandomSource) {
+	for i := range data {
+		data[i] = min + r.Float32()*(max-min)
+	}
+}
+
+func fillGaussian(data []float32, mean, std float32, r *RandomSource) {
+	for i := range data {
+		data[i] = mean + std*float32(r.NormFloat64())
+	}
+}
@@ -0,0 +1,144 @@
+package godnn
+
+import (
+	"math"
+	"testing"
+)
+
+const (
+	gradientCheckStep      = 1e-2
+	gradientCheckThreshold = 1e-2
+)
+
+// checkGradient compares the diffs FeedBackward gives the bottoms in checked
+// and all params with central finite differences of the objective
+// sum(top * weights), where weights are fixed random values. The layer must be
+// set up on d and not run in-place.
+func checkGradient(t *testing.T, layer Layer, d *LayerData, checked ...int) {
+	r := NewRandomSource(DefaultSeed)
+	weights := make([][]float32, len(d.Top))
+	for i, top := range d.Top {
+		weights[i] = make([]float32, top.Dim.Size())
+		fillUniform(weights[i], -1, 1, r)
+	}
+	objective := func() float64 {
+		layer.FeedForward(d)
+		sum := 0.0
+		for i, top := range d.Top {
+			for j, value := range top.Data.CpuValues() {
+				sum += float64(value) * float64(weights[i][j])
+			}
+		}
+		return sum
+	}
+
+	objective()
+	for i, top := range d.Top {
+		Copy32(weights[i], top.Diff.MutableCpuValues(), len(weights[i]), 0)
+	}
+	var blobs []*Blob
+	for _, b := range checked {
+		blobs = append(blobs, d.Bottom[b])
+	}
+	blobs = append(blobs, d.Params...)
+	for _, b := range blobs {
+		b.ClearDiff()
+	}
+	layer.FeedBackward(d, true)
+
+	for _, b := range blobs {
+		data := b.Data.MutableCpuValues()
+		diff := b.Diff.CpuValues()
+		for i := range data {
+			value := data[i]
+			data[i] = value + gradientCheckStep
+			positive := objective()
+			data[i] = value - gradientCheckStep
+			negative := objective()
+			data[i] = value
+
+			estimate := (positive - negative) / (2 * gradientCheckStep)
+			scale := math.Max(1, math.Max(math.Abs(estimate), math.Abs(float64(diff[i]))))
+			if math.Abs(estimate-float64(diff[i])) > gradientCheckThreshold*scale {
+				t.Errorf("%s %s[%d]: diff %v, estimate %v", layer.LayerName(), b.Name, i, diff[i], estimate)
+			}
+		}
+	}
+}
+
+func randomBlob(name string, dim *BlobPoint, r *RandomSource) *Blob {
+	b := NewBlob(name, dim)
+	fillUniform(b.Data.MutableCpuValues(), -1, 1, r)
+	return b
+}
+
+func TestLayerNormLayerGradient(t *testing.T) {
+	r := NewRandomSource(DefaultSeed)
+	for _, axis := range []int{AxisChannel, AxisWidth} {
+		layer := NewLayerNormLayer(BaseLayer{Name: "norm", BottomNames: []string{"in"}, TopNames: []string{"out"}}, axis)
+		d := &LayerData{Bottom: []*Blob{randomBlob("in", &BlobPoint{2, 3, 1, 4}, r)}}
+		if err := layer.Setup(d); err != nil {
+			t.Fatal(err)
+		}
+		for _, param := range d.Params {
+			fillUniform(param.Data.MutableCpuValues(), -1, 1, r)
+		}
+		checkGradient(t, layer, d, 0)
+	}
+}
+
+func TestPositionalEncodingLayerGradient(t *testing.T) {
+	r := NewRandomSource(DefaultSeed)
+	layer := NewPositionalEncodingLayer(BaseLayer{Name: "position", BottomNames: []string{"in"}, TopNames: []string{"out"}})
+	d := &LayerData{Bottom: []*Blob{randomBlob("in", &BlobPoint{2, 3, 1, 4}, r)}}
+	if err := layer.Setup(d); err != nil {
+		t.Fatal(err)
+	}
+	checkGradient(t, layer, d, 0)
+}
+
+func TestMultiHeadAttentionLayerGradient(t *testing.T) {
+	const sequenceLength = 4
+	paddingMask := []float32{
+		1, 1, 1, 0,
+		1, 1, 0, 0,
+	}
+	fullMask := []float32{
+		1, 0, 1, 0,
+		1, 1, 0, 0,
+		0, 1, 1, 1,
+		1, 1, 1, 0,
+	}
+	tests := []struct {
+		name   string
+		causal bool
+		mask   []float32
+		dim    BlobPoint
+	}{
+		{"attention", false, nil, BlobPoint{}},
+		{"causal", true, nil, BlobPoint{}},
+		{"padding", false, paddingMask, BlobPoint{2, 1, 1, sequenceLength}},
+		{"causal_padding", true, paddingMask, BlobPoint{2, 1, 1, sequenceLength}},
+		{"full_mask", false, fullMask, BlobPoint{1, 1, sequenceLength, sequenceLength}},
+	}
+
+	r := NewRandomSource(DefaultSeed)
+	for _, test := range tests {
+		for _, includeBias := range []bool{false, true} {
+			baseLayer := BaseLayer{Name: test.name, BottomNames: []string{"in"}, TopNames: []string{"out"}}
+			d := &LayerData{Bottom: []*Blob{randomBlob("in", &BlobPoint{2, sequenceLength, 1, 4}, r)}}
+			if test.mask != nil {
+				mask := NewBlob("mask", &test.dim)
+				Copy32(test.mask, mask.Data.MutableCpuValues(), len(test.mask), 0)
+				baseLayer.BottomNames = append(baseLayer.BottomNames, "mask")
+				d.Bottom = append(d.Bottom, mask)
+			}
+			layer := NewMultiHeadAttentionLayer(baseLayer, 2, test.causal, includeBias)
+			layer.BiasFiller = &UniformFiller{Min: -1, Max: 1}
+			if err := layer.Setup(d); err != nil {
+				t.Fatal(err)
+			}
+			checkGradient(t, layer, d, 0)
+		}
+	}
+}
@@ -10,10 +10,22 @@ var (
 	ErrInvalidTopBlobNames    = errors.New("invalid top blob names")
 )
 
+// LayerData holds the blobs of a layer and the random source of its param
+// fillers and any stochastic computation.
 type LayerData struct {
 	Bottom []*Blob
 	Top    []*Blob
 	Params []*Blob
+	Random *RandomSource
+}
+
+// RandomSource returns the random source of the layer, seeded with
+// DefaultSeed when the layer is used outside a network.
+func (d *LayerData) RandomSource() *RandomSource {
+	if d.Random == nil {
+		d.Random = NewRandomSource(DefaultSeed)
+	}
+	return d.Random
 }
 
 func (d *LayerData) DebugLayerData() {
@@ -26,30 +38,66 @@ func (d *LayerData) DebugLayerData() {
 	}
 }
 
+type Phase int
+
+const (
+	PhaseTrain Phase = iota
+	PhaseTest
+)
+
+// Layer is a step of a network. FeedBackward adds the gradients of the params
+// to their diffs, so they accumulate over passes until the diffs are cleared.
 type Layer interface {
 	LayerName() string
 	TopBlobNames() []string
 	BottomBlobNames() []string
+	TopLossWeights() []float32
+	LayerParamSpecs() []ParamSpec
 
 	Setup(d *LayerData) error
 	FeedForward(d *LayerData) float32
 	FeedBackward(d *LayerData, paramPropagate bool)
 }
 
+// LossLayer is implemented by layers whose first top is a loss, which then
+// counts towards the network objective with a loss weight of 1 by default.
+type LossLayer interface {
+	Layer
+	IsLoss() bool
+}
+
+// BaseLayer holds the blob wiring of a layer. LossWeights, when set, has one
+// weight per top: tops with a non-zero weight add the weighted sum of their
+// data to the network objective.
 type BaseLayer struct {
 	Name        string
 	BottomNames []string
 	TopNames    []string
+	LossWeights []float32
+	ParamSpecs  []ParamSpec
 }
 
-func (l *BaseLayer) String() string            { return l.Name }
-func (l *BaseLayer) LayerName() string         { return l.Name }
-func (l *BaseLayer) TopBlobNames() []string    { return l.TopNames }
-func (l *BaseLayer) BottomBlobNames() []string { return l.BottomNames }
+// ParamSpec scales the learning rate and weight decay the solver applies to a
+// param. When a layer has ParamSpecs there is one per param, in the order the
+// layer creates them (weights before biases); without them both are 1.
+type ParamSpec struct {
+	LRMult    float32
+	DecayMult float32
+}
+
+func (l *BaseLayer) String() string               { return l.Name }
+func (l *BaseLayer) LayerName() string            { return l.Name }
+func (l *BaseLayer) TopBlobNames() []string       { return l.TopNames }
+func (l *BaseLayer) BottomBlobNames() []string    { return l.BottomNames }
+func (l *BaseLayer) TopLossWeights() []float32    { return l.LossWeights }
+func (l *BaseLayer) LayerParamSpecs() []ParamSpec { return l.ParamSpecs }
+func (l *BaseLayer) inPlace(topIndex, bottomIndex int) bool {
+	return l.TopNames[topIndex] == l.BottomNames[bottomIndex]
+}
 func (l *BaseLayer) checkNames(expectedBottom, expectedTop int) error {
 	err := l.checkBottomNames(expectedBottom)
 	if err != nil {
-		return nil
+		return err
 	}
 	return l.checkTopNames(expectedTop)
 }
@@ -0,0 +1,594 @@
+package godnn
+
+import (
+	"errors"
+	"github.com/gonum/blas"
+	"math"
+)
+
+var (
+	ErrLayerNormLayerInvalidAxis            = errors.New("invalid layer norm axis")
+	ErrMultiHeadAttentionLayerInvalidHeads  = errors.New("invalid number of heads: must divide the feature size")
+	ErrMultiHeadAttentionLayerInvalidMask   = errors.New("invalid attention mask: must be (batch or 1) x sequence or sequence x sequence")
+	ErrPositionalEncodingLayerInvalidBottom = errors.New("invalid bottom data: features must be a multiple of 2")
+)
+
+// Sequence blobs are laid out as (batch, sequence, 1, features), which is the
+// top of an EmbeddingLayer and of a FullyConnectedLayer applied at AxisWidth.
+
+// LayerNormLayer normalizes the values of each bottom item from Axis on to zero
+// mean and unit variance, then scales and shifts them by learned params. An
+// Axis of 0 defaults to AxisChannel.
+type LayerNormLayer struct {
+	BaseLayer
+	Axis        int
+	Epsilon     float32
+	numRows     int
+	rowSize     int
+	scaleParams *Blob
+	shiftParams *Blob
+	normalized  *Blob
+	invStd      []float32
+}
+
+var _ = Layer(new(LayerNormLayer))
+
+func (l *LayerNormLayer) Setup(d *LayerData) error {
+	err := l.checkNames(1, 1)
+	if err != nil {
+		return err
+	}
+	if l.Axis == 0 {
+		l.Axis = AxisChannel
+	}
+	if l.Axis < 0 || l.Axis >= NumAxes {
+		return ErrLayerNormLayerInvalidAxis
+	}
+	if l.Epsilon == 0 {
+		l.Epsilon = 1e-5
+	}
+
+	bottomDim := d.Bottom[0].Dim
+	l.numRows = bottomDim.Count(0, l.Axis)
+	l.rowSize = bottomDim.Count(l.Axis, NumAxes)
+
+	if d.Params == nil {
+		l.scaleParams = NewBlob(l.LayerName()+"_scale", &BlobPoint{1, 1, 1, l.rowSize})
+		l.shiftParams = NewBlob(l.LayerName()+"_shift", &BlobPoint{1, 1, 1, l.rowSize})
+		d.Params = []*Blob{l.scaleParams, l.shiftParams}
+		Set32(l.scaleParams.Data.MutableCpuValues(), 1)
+		Set32(l.shiftParams.Data.MutableCpuValues(), 0)
+	} else {
+		l.scaleParams = d.Params[0]
+		l.shiftParams = d.Params[1]
+	}
+
+	l.normalized = NewBlob(l.LayerName()+"_normalized", &bottomDim)
+	l.invStd = make([]float32, l.numRows)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = NewBlob(l.TopNames[0], &bottomDim)
+	}
+
+	return nil
+}
+
+func (l *LayerNormLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.MutableCpuValues()
+	normalizedData := l.normalized.Data.MutableCpuValues()
+	scaleData := l.scaleParams.Data.CpuValues()
+	shiftData := l.shiftParams.Data.CpuValues()
+
+	for r := 0; r < l.numRows; r++ {
+		bottomRow := Subslice32(bottomData, r, l.rowSize)
+		normalizedRow := Subslice32(normalizedData, r, l.rowSize)
+		topRow := Subslice32(topData, r, l.rowSize)
+
+		mean := float32(0)
+		for _, v := range bottomRow {
+			mean += v
+		}
+		mean /= float32(l.rowSize)
+		variance := float32(0)
+		for _, v := range bottomRow {
+			variance += Sq32(v - mean)
+		}
+		variance /= float32(l.rowSize)
+		l.invStd[r] = 1 / Sqrt32(variance+l.Epsilon)
+
+		for i, v := range bottomRow {
+			normalizedRow[i] = (v - mean) * l.invStd[r]
+			topRow[i] = normalizedRow[i]*scaleData[i] + shiftData[i]
+		}
+	}
+	return 0
+}
+
+func (l *LayerNormLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	topDiff := d.Top[0].Diff.CpuValues()
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	normalizedData := l.normalized.Data.CpuValues()
+	normalizedDiff := l.normalized.Diff.MutableCpuValues()
+	scaleData := l.scaleParams.Data.CpuValues()
+
+	if paramPropagate {
+		scaleDiff := l.scaleParams.Diff.MutableCpuValues()
+		shiftDiff := l.shiftParams.Diff.MutableCpuValues()
+		for r := 0; r < l.numRows; r++ {
+			topRow := Subslice32(topDiff, r, l.rowSize)
+			normalizedRow := Subslice32(normalizedData, r, l.rowSize)
+			for i, diff := range topRow {
+				scaleDiff[i] += diff * normalizedRow[i]
+				shiftDiff[i] += diff
+			}
+		}
+	}
+
+	n := float32(l.rowSize)
+	for r := 0; r < l.numRows; r++ {
+		topRow := Subslice32(topDiff, r, l.rowSize)
+		normalizedRow := Subslice32(normalizedData, r, l.rowSize)
+		normalizedDiffRow := Subslice32(normalizedDiff, r, l.rowSize)
+		bottomRow := Subslice32(bottomDiff, r, l.rowSize)
+
+		sumDiff := float32(0)
+		sumDiffNormalized := float32(0)
+		for i, diff := range topRow {
+			normalizedDiffRow[i] = diff * scaleData[i]
+			sumDiff += normalizedDiffRow[i]
+			sumDiffNormalized += normalizedDiffRow[i] * normalizedRow[i]
+		}
+		for i := range bottomRow {
+			bottomRow[i] = l.invStd[r] / n *
+				(n*normalizedDiffRow[i] - sumDiff - normalizedRow[i]*sumDiffNormalized)
+		}
+	}
+}
+
+func NewLayerNormLayer(baseLayer BaseLayer, axis int) *LayerNormLayer {
+	return &LayerNormLayer{BaseLayer: baseLayer, Axis: axis}
+}
+
+// PositionalEncodingLayer adds the sinusoidal position encoding to a sequence
+// blob. It can be run in-place.
+type PositionalEncodingLayer struct {
+	BaseLayer
+	encoding []float32
+}
+
+var _ = Layer(new(PositionalEncodingLayer))
+
+func (l *PositionalEncodingLayer) Setup(d *LayerData) error {
+	err := l.checkNames(1, 1)
+	if err != nil {
+		return err
+	}
+
+	bottomDim := d.Bottom[0].Dim
+	sequenceLength := bottomDim.Channel
+	features := bottomDim.SpatialSize()
+	if features%2 != 0 {
+		return ErrPositionalEncodingLayerInvalidBottom
+	}
+
+	l.encoding = make([]float32, sequenceLength*features)
+	for t := 0; t < sequenceLength; t++ {
+		for i := 0; i < features; i += 2 {
+			angle := float64(t) / math.Pow(10000, float64(i)/float64(features))
+			l.encoding[t*features+i] = float32(math.Sin(angle))
+			l.encoding[t*features+i+1] = float32(math.Cos(angle))
+		}
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		if l.inPlace(0, 0) {
+			d.Top[0] = d.Bottom[0]
+		} else {
+			d.Top[0] = NewBlob(l.TopNames[0], &bottomDim)
+		}
+	}
+
+	return nil
+}
+
+func (l *PositionalEncodingLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.MutableCpuValues()
+	batchSize := d.Bottom[0].Dim.BatchSize()
+	for n := 0; n < d.Bottom[0].Dim.Batch; n++ {
+		bottomSlice := Subslice32(bottomData, n, batchSize)
+		topSlice := Subslice32(topData, n, batchSize)
+		BinaryEval32(bottomSlice, l.encoding, topSlice, Add32)
+	}
+	return 0
+}
+
+func (l *PositionalEncodingLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	if d.Top[0] != d.Bottom[0] {
+		topDiff := d.Top[0].Diff.CpuValues()
+		Copy32(topDiff, d.Bottom[0].Diff.MutableCpuValues(), len(topDiff), 0)
+	}
+}
+
+func NewPositionalEncodingLayer(baseLayer BaseLayer) *PositionalEncodingLayer {
+	return &PositionalEncodingLayer{BaseLayer: baseLayer}
+}
+
+const (
+	attentionQuery = iota
+	attentionKey
+	attentionValue
+	attentionOutput
+	numAttentionProjections
+)
+
+// MultiHeadAttentionLayer is scaled dot-product self-attention over a sequence
+// blob with NumHeads heads and learned query, key, value and output
+// projections. An optional second bottom masks attention, zeros are masked:
+// either a key padding mask of (batch, sequence) values or a full
+// (batch or 1, sequence x sequence) mask. Causal masks every later position.
+type MultiHeadAttentionLayer struct {
+	BaseLayer
+	NumHeads    int
+	Causal      bool
+	IncludeBias bool
+	// WeightFiller defaults to a XavierFiller, BiasFiller to zero
+	WeightFiller Filler
+	BiasFiller   Filler
+
+	numBatches     int
+	sequenceLength int
+	features       int
+	headSize       int
+	scale          float32
+	weightParams   []*Blob
+	biasParams     []*Blob
+	biasMultiplier *Blob
+	projections    []*Blob // query, key and value split by head, (batch, head, sequence, head size)
+	attention      *Blob   // softmax of the scores, (batch, head, sequence, sequence)
+	heads          *Blob   // attention output of all heads, (batch, sequence, 1, features)
+	projectionTemp *Blob
+	headsTemp      *Blob
+	attentionTemp  *Blob
+}
+
+var _ = Layer(new(MultiHeadAttentionLayer))
+
+func (l *MultiHeadAttentionLayer) Setup(d *LayerData) error {
+	if len(l.BottomNames) != 1 && len(l.BottomNames) != 2 {
+		return ErrInvalidBottomBlobNames
+	}
+	err := l.checkTopNames(1)
+	if err != nil {
+		return err
+	}
+
+	bottomDim := d.Bottom[0].Dim
+	l.numBatches = bottomDim.Batch
+	l.sequenceLength = bottomDim.Channel
+	l.features = bottomDim.SpatialSize()
+	if l.NumHeads <= 0 || l.features%l.NumHeads != 0 {
+		return ErrMultiHeadAttentionLayerInvalidHeads
+	}
+	l.headSize = l.features / l.NumHeads
+	l.scale = 1 / Sqrt32(float32(l.headSize))
+
+	if len(d.Bottom) > 1 {
+		maskDim := d.Bottom[1].Dim
+		if maskDim.Batch != 1 && maskDim.Batch != l.numBatches {
+			return ErrMultiHeadAttentionLayerInvalidMask
+		}
+		if maskDim.BatchSize() != l.sequenceLength && maskDim.BatchSize() != l.sequenceLength*l.sequenceLength {
+			return ErrMultiHeadAttentionLayerInvalidMask
+		}
+	}
+
+	if d.Params == nil {
+		names := []string{"_query", "_key", "_value", "_output"}
+		l.weightParams = make([]*Blob, numAttentionProjections)
+		for p := range l.weightParams {
+			l.weightParams[p] = NewBlob(l.LayerName()+names[p]+"_weight", &BlobPoint{1, 1, l.features, l.features})
+			fillParam(d, l.weightParams[p], l.WeightFiller, &XavierFiller{}, l.features, l.features)
+		}
+		d.Params = append([]*Blob{}, l.weightParams...)
+		if l.IncludeBias {
+			l.biasParams = make([]*Blob, numAttentionProjections)
+			for p := range l.biasParams {
+				l.biasParams[p] = NewBlob(l.LayerName()+names[p]+"_bias", &BlobPoint{1, 1, 1, l.features})
+				fillParam(d, l.biasParams[p], l.BiasFiller, &ConstantFiller{}, 1, l.features)
+			}
+			d.Params = append(d.Params, l.biasParams...)
+		}
+	} else {
+		l.weightParams = d.Params[:numAttentionProjections]
+		if l.IncludeBias {
+			l.biasParams = d.Params[numAttentionProjections:]
+		}
+	}
+
+	if l.IncludeBias {
+		l.biasMultiplier = NewBlob(l.LayerName()+"_biasMultiplier", &BlobPoint{1, 1, 1, l.sequenceLength})
+		Set32(l.biasMultiplier.Data.MutableCpuValues(), 1)
+	}
+
+	headsDim := &BlobPoint{l.numBatches, l.NumHeads, l.sequenceLength, l.headSize}
+	l.projections = make([]*Blob, attentionOutput)
+	for p := range l.projections {
+		l.projections[p] = NewBlob(l.LayerName()+"_projection", headsDim)
+	}
+	l.attention = NewBlob(l.LayerName()+"_attention",
+		&BlobPoint{l.numBatches, l.NumHeads, l.sequenceLength, l.sequenceLength})
+	l.heads = NewBlob(l.LayerName()+"_heads", &BlobPoint{l.numBatches, l.sequenceLength, 1, l.features})
+	l.projectionTemp = NewBlob(l.LayerName()+"_projectionTemp", &BlobPoint{1, l.sequenceLength, 1, l.features})
+	l.headsTemp = NewBlob(l.LayerName()+"_headsTemp", &BlobPoint{1, l.NumHeads, l.sequenceLength, l.headSize})
+	l.attentionTemp = NewBlob(l.LayerName()+"_attentionTemp", &BlobPoint{1, 1, l.sequenceLength, l.sequenceLength})
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = NewBlob(l.TopNames[0], &bottomDim)
+	}
+
+	return nil
+}
+
+// splitHeads copies a (sequence, features) matrix into (head, sequence, head size).
+func (l *MultiHeadAttentionLayer) splitHeads(src, dst []float32) {
+	for h := 0; h < l.NumHeads; h++ {
+		for t := 0; t < l.sequenceLength; t++ {
+			Copy32(src, dst[(h*l.sequenceLength+t)*l.headSize:], l.headSize, t*l.features+h*l.headSize)
+		}
+	}
+}
+
+// mergeHeads copies a (head, sequence, head size) matrix into (sequence, features).
+func (l *MultiHeadAttentionLayer) mergeHeads(src, dst []float32) {
+	for h := 0; h < l.NumHeads; h++ {
+		for t := 0; t < l.sequenceLength; t++ {
+			Copy32(src, dst[t*l.features+h*l.headSize:], l.headSize, (h*l.sequenceLength+t)*l.headSize)
+		}
+	}
+}
+
+func (l *MultiHeadAttentionLayer) masked(d *LayerData, n, query, key int) bool {
+	if l.Causal && key > query {
+		return true
+	}
+	if len(d.Bottom) < 2 {
+		return false
+	}
+	mask := d.Bottom[1]
+	if mask.Dim.Batch == 1 {
+		n = 0
+	}
+	maskSlice := Subslice32(mask.Data.CpuValues(), n, mask.Dim.BatchSize())
+	if len(maskSlice) == l.sequenceLength {
+		return maskSlice[key] == 0
+	}
+	return maskSlice[query*l.sequenceLength+key] == 0
+}
+
+// project computes the (sequence, features) projection p of the input.
+func (l *MultiHeadAttentionLayer) project(p int, input, output []float32) {
+	Gemm32(blas.NoTrans, blas.Trans, l.sequenceLength, l.features, l.features,
+		1, input, l.weightParams[p].Data.CpuValues(), 0, output)
+	if l.IncludeBias {
+		Gemm32(blas.NoTrans, blas.NoTrans, l.sequenceLength, l.features, 1,
+			1, l.biasMultiplier.Data.CpuValues(), l.biasParams[p].Data.CpuValues(), 1, output)
+	}
+}
+
+// projectBackward accumulates the param diffs of projection p and returns the
+// input diff through it, added to inputDiff unless overwrite is set.
+func (l *MultiHeadAttentionLayer) projectBackward(p int, input, outputDiff, inputDiff []float32,
+	paramPropagate, overwrite bool) {
+	if paramPropagate {
+		Gemm32(blas.Trans, blas.NoTrans, l.features, l.features, l.sequenceLength,
+			1, outputDiff, input, 1, l.weightParams[p].Diff.MutableCpuValues())
+		if l.IncludeBias {
+			Gemv32(blas.Trans, l.sequenceLength, l.features,
+				1, outputDiff, l.biasMultiplier.Data.CpuValues(), 1, l.biasParams[p].Diff.MutableCpuValues())
+		}
+	}
+	beta := float32(1)
+	if overwrite {
+		beta = 0
+	}
+	Gemm32(blas.NoTrans, blas.NoTrans, l.sequenceLength, l.features, l.features,
+		1, outputDiff, l.weightParams[p].Data.CpuValues(), beta, inputDiff)
+}
+
+func (l *MultiHeadAttentionLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.MutableCpuValues()
+	attentionData := l.attention.Data.MutableCpuValues()
+	headsData := l.heads.Data.MutableCpuValues()
+	projectionTemp := l.projectionTemp.Data.MutableCpuValues()
+	headsTemp := l.headsTemp.Data.MutableCpuValues()
+	sequenceSize := l.sequenceLength * l.features
+	headSize := l.sequenceLength * l.headSize
+	scoresSize := l.sequenceLength * l.sequenceLength
+
+	for n := 0; n < l.numBatches; n++ {
+		bottomSlice := Subslice32(bottomData, n, sequenceSize)
+		for p, projection := range l.projections {
+			l.project(p, bottomSlice, projectionTemp)
+			l.splitHeads(projectionTemp, Subslice32(projection.Data.MutableCpuValues(), n, sequenceSize))
+		}
+		queryData := Subslice32(l.projections[attentionQuery].Data.CpuValues(), n, sequenceSize)
+		keyData := Subslice32(l.projections[attentionKey].Data.CpuValues(), n, sequenceSize)
+		valueData := Subslice32(l.projections[attentionValue].Data.CpuValues(), n, sequenceSize)
+
+		for h := 0; h < l.NumHeads; h++ {
+			scores := Subslice32(attentionData, n*l.NumHeads+h, scoresSize)
+			Gemm32(blas.NoTrans, blas.Trans, l.sequenceLength, l.sequenceLength, l.headSize,
+				l.scale, Subslice32(queryData, h, headSize), Subslice32(keyData, h, headSize), 0, scores)
+
+			// Masked softmax over the keys of each query
+			for i := 0; i < l.sequenceLength; i++ {
+				row := Subslice32(scores, i, l.sequenceLength)
+				maxScore := float32(math.Inf(-1))
+				for j, score := range row {
+					if !l.masked(d, n, i, j) {
+						maxScore = Max32(maxScore, score)
+					}
+				}
+				sum := float32(0)
+				for j, score := range row {
+					if l.masked(d, n, i, j) {
+						row[j] = 0
+					} else {
+						row[j] = Exp32(score - maxScore)
+						sum += row[j]
+					}
+				}
+				if sum > 0 {
+					Scal32(len(row), 1/sum, row)
+				}
+			}
+
+			Gemm32(blas.NoTrans, blas.NoTrans, l.sequenceLength, l.headSize, l.sequenceLength,
+				1, scores, Subslice32(valueData, h, headSize), 0, Subslice32(headsTemp, h, headSize))
+		}
+
+		headsSlice := Subslice32(headsData, n, sequenceSize)
+		l.mergeHeads(headsTemp, headsSlice)
+		l.project(attentionOutput, headsSlice, Subslice32(topData, n, sequenceSize))
+	}
+	return 0
+}
+
+func (l *MultiHeadAttentionLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	topDiff := d.Top[0].Diff.CpuValues()
+	attentionData := l.attention.Data.CpuValues()
+	headsData := l.heads.Data.CpuValues()
+	projectionTemp := l.projectionTemp.Data.MutableCpuValues()
+	headsTemp := l.headsTemp.Data.MutableCpuValues()
+	scoresDiff := l.attentionTemp.Diff.MutableCpuValues()
+	sequenceSize := l.sequenceLength * l.features
+	headSize := l.sequenceLength * l.headSize
+	scoresSize := l.sequenceLength * l.sequenceLength
+
+	for n := 0; n < l.numBatches; n++ {
+		bottomSlice := Subslice32(bottomData, n, sequenceSize)
+		bottomDiffSlice := Subslice32(bottomDiff, n, sequenceSize)
+		queryData := Subslice32(l.projections[attentionQuery].Data.CpuValues(), n, sequenceSize)
+		keyData := Subslice32(l.projections[attentionKey].Data.CpuValues(), n, sequenceSize)
+		valueData := Subslice32(l.projections[attentionValue].Data.CpuValues(), n, sequenceSize)
+		queryDiff := Subslice32(l.projections[attentionQuery].Diff.MutableCpuValues(), n, sequenceSize)
+		keyDiff := Subslice32(l.projections[attentionKey].Diff.MutableCpuValues(), n, sequenceSize)
+		valueDiff := Subslice32(l.projections[attentionValue].Diff.MutableCpuValues(), n, sequenceSize)
+
+		// Output projection, headsTemp holds the diff of each head's output
+		l.projectBackward(attentionOutput, Subslice32(headsData, n, sequenceSize),
+			Subslice32(topDiff, n, sequenceSize), projectionTemp, paramPropagate, true)
+		l.splitHeads(projectionTemp, headsTemp)
+
+		for h := 0; h < l.NumHeads; h++ {
+			attention := Subslice32(attentionData, n*l.NumHeads+h, scoresSize)
+			headDiff := Subslice32(headsTemp, h, headSize)
+
+			Gemm32(blas.Trans, blas.NoTrans, l.sequenceLength, l.headSize, l.sequenceLength,
+				1, attention, headDiff, 0, Subslice32(valueDiff, h, headSize))
+			Gemm32(blas.NoTrans, blas.Trans, l.sequenceLength, l.sequenceLength, l.headSize,
+				1, headDiff, Subslice32(valueData, h, headSize), 0, scoresDiff)
+
+			// Softmax gradient, masked entries have zero attention and so zero diff
+			for i := 0; i < l.sequenceLength; i++ {
+				attentionRow := Subslice32(attention, i, l.sequenceLength)
+				diffRow := Subslice32(scoresDiff, i, l.sequenceLength)
+				dot := Dot32(l.sequenceLength, attentionRow, 1, diffRow, 1)
+				for j := range diffRow {
+					diffRow[j] = attentionRow[j] * (diffRow[j] - dot)
+				}
+			}
+
+			Gemm32(blas.NoTrans, blas.NoTrans, l.sequenceLength, l.headSize, l.sequenceLength,
+				l.scale, scoresDiff, Subslice32(keyData, h, headSize), 0, Subslice32(queryDiff, h, headSize))
+			Gemm32(blas.Trans, blas.NoTrans, l.sequenceLength, l.headSize, l.sequenceLength,
+				l.scale, scoresDiff, Subslice32(queryData, h, headSize), 0, Subslice32(keyDiff, h, headSize))
+		}
+
+		for p, projection := range l.projections {
+			l.mergeHeads(Subslice32(projection.Diff.CpuValues(), n, sequenceSize), projectionTemp)
+			l.projectBackward(p, bottomSlice, projectionTemp, bottomDiffSlice, paramPropagate, p == 0)
+		}
+	}
+}
+
+func NewMultiHeadAttentionLayer(baseLayer BaseLayer, numHeads int, causal, includeBias bool) *MultiHeadAttentionLayer {
+	return &MultiHeadAttentionLayer{
+		BaseLayer:   baseLayer,
+		NumHeads:    numHeads,
+		Causal:      causal,
+		IncludeBias: includeBias,
+	}
+}
+
+// NewTransformerEncoderLayers builds a post-norm Transformer encoder block
+// reading the sequence blob bottomName and writing topName: self-attention
+// and a ReLU feed-forward network, each followed by a residual sum and layer
+// normalization. maskName is the optional attention mask blob, or "".
+func NewTransformerEncoderLayers(name, bottomName, maskName, topName string,
+	features, numHeads, feedForwardSize int, causal bool) []Layer {
+	attentionBottoms := []string{bottomName}
+	if maskName != "" {
+		attentionBottoms = append(attentionBottoms, maskName)
+	}
+	return []Layer{
+		NewMultiHeadAttentionLayer(BaseLayer{
+			Name:        name + "_attention",
+			BottomNames: attentionBottoms,
+			TopNames:    []string{name + "_attention"},
+		}, numHeads, causal, true),
+		NewEltwiseLayer(BaseLayer{
+			Name:        name + "_attention_residual",
+			BottomNames: []string{bottomName, name + "_attention"},
+			TopNames:    []string{name + "_attention_residual"},
+		}, EltwiseOpSum, nil),
+		NewLayerNormLayer(BaseLayer{
+			Name:        name + "_attention_norm",
+			BottomNames: []string{name + "_attention_residual"},
+			TopNames:    []string{name + "_attention_norm"},
+		}, AxisWidth),
+		&FullyConnectedLayer{
+			BaseLayer: BaseLayer{
+				Name:        name + "_ff1",
+				BottomNames: []string{name + "_attention_norm"},
+				TopNames:    []string{name + "_ff1"},
+			},
+			NumOutputs:  feedForwardSize,
+			IncludeBias: true,
+			Axis:        AxisWidth,
+		},
+		NewReLULayer(BaseLayer{
+			Name:        name + "_ff1_relu",
+			BottomNames: []string{name + "_ff1"},
+			TopNames:    []string{name + "_ff1"},
+		}, 0),
+		&FullyConnectedLayer{
+			BaseLayer: BaseLayer{
+				Name:        name + "_ff2",
+				BottomNames: []string{name + "_ff1"},
+				TopNames:    []string{name + "_ff2"},
+			},
+			NumOutputs:  features,
+			IncludeBias: true,
+			Axis:        AxisWidth,
+		},
+		NewEltwiseLayer(BaseLayer{
+			Name:        name + "_ff_residual",
+			BottomNames: []string{name + "_attention_norm", name + "_ff2"},
+			TopNames:    []string{name + "_ff_residual"},
+		}, EltwiseOpSum, nil),
+		NewLayerNormLayer(BaseLayer{
+			Name:        name + "_ff_norm",
+			BottomNames: []string{name + "_ff_residual"},
+			TopNames:    []string{topName},
+		}, AxisWidth),
+	}
+}
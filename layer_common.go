@@ -1,13 +1,22 @@
 package godnn
 
 import (
+	"errors"
 	"github.com/gonum/blas"
+	"sort"
 )
 
+// FullyConnectedLayer flattens the bottom axes from Axis on into the inputs
+// of each output, e.g. AxisWidth applies it to every position of a (batch,
+// sequence, 1, features) bottom. An Axis of 0 defaults to AxisChannel. The
+// weights default to a XavierFiller and the biases to zero.
 type FullyConnectedLayer struct {
 	BaseLayer
 	NumOutputs     int
 	IncludeBias    bool
+	Axis           int
+	WeightFiller   Filler
+	BiasFiller     Filler
 	m              int
 	n              int
 	k              int
@@ -23,24 +32,32 @@ func (l *FullyConnectedLayer) Setup(d *LayerData) error {
 	if err != nil {
 		return err
 	}
+	if l.Axis == 0 {
+		l.Axis = AxisChannel
+	}
+	if l.Axis < 0 || l.Axis >= NumAxes {
+		return ErrInvalidAxis
+	}
 
 	bottomDim := d.Bottom[0].Dim
-	l.m = bottomDim.Batch
+	l.m = bottomDim.Count(0, l.Axis)
 	l.n = l.NumOutputs
-	l.k = bottomDim.BatchSize()
+	l.k = bottomDim.Count(l.Axis, NumAxes)
 
 	if d.Params == nil {
 		l.weightParams = NewBlob(l.LayerName()+"_weight", &BlobPoint{1, 1, l.n, l.k})
 		d.Params = []*Blob{l.weightParams}
+		fillParam(d, l.weightParams, l.WeightFiller, &XavierFiller{}, l.k, l.n)
 		if l.IncludeBias {
 			l.biasParams = NewBlob(l.LayerName()+"_bias", &BlobPoint{1, 1, 1, l.n})
 			d.Params = append(d.Params, l.biasParams)
+			fillParam(d, l.biasParams, l.BiasFiller, &ConstantFiller{}, 1, l.n)
 		}
-		Set32(l.weightParams.Data.MutableCpuValues(), 0)
-		Set32(l.biasParams.Data.MutableCpuValues(), 0)
 	} else {
 		l.weightParams = d.Params[0]
-		l.biasParams = d.Params[1]
+		if l.IncludeBias {
+			l.biasParams = d.Params[1]
+		}
 	}
 
 	if l.IncludeBias {
@@ -49,8 +66,14 @@ func (l *FullyConnectedLayer) Setup(d *LayerData) error {
 	}
 
 	if d.Top == nil {
+		topShape := bottomDim.Shape()
+		topShape[l.Axis] = l.n
+		for axis := l.Axis + 1; axis < NumAxes; axis++ {
+			topShape[axis] = 1
+		}
+		topDim := BlobPointFromShape(topShape)
 		d.Top = make([]*Blob, 1)
-		d.Top[0] = NewBlob(l.TopNames[0], &BlobPoint{l.m, l.n, 1, 1})
+		d.Top[0] = NewBlob(l.TopNames[0], &topDim)
 	}
 
 	return nil
@@ -76,13 +99,13 @@ func (l *FullyConnectedLayer) FeedBackward(d *LayerData, paramPropagate bool) {
 		bottomData := d.Bottom[0].Data.CpuValues()
 		weightParamDiffs := l.weightParams.Diff.MutableCpuValues()
 		// Gradient w.r.t. weight
-		Gemm32(blas.Trans, blas.NoTrans, l.n, l.k, l.m, 1, topDiff, bottomData, 0, weightParamDiffs)
+		Gemm32(blas.Trans, blas.NoTrans, l.n, l.k, l.m, 1, topDiff, bottomData, 1, weightParamDiffs)
 
 		if l.IncludeBias {
 			biasMultiplier := l.biasMultiplier.Data.CpuValues()
 			biasParamsDiff := l.biasParams.Diff.MutableCpuValues()
 			// Gradient w.r.t. bias
-			Gemv32(blas.Trans, l.m, l.n, 1, topDiff, biasMultiplier, 0, biasParamsDiff)
+			Gemv32(blas.Trans, l.m, l.n, 1, topDiff, biasMultiplier, 1, biasParamsDiff)
 		}
 	}
 
@@ -199,3 +222,680 @@ func (l *SoftmaxLayer) FeedBackward(d *LayerData, paramPropagate bool) {
 func NewSoftmaxLayer(baseLayer BaseLayer) *SoftmaxLayer {
 	return &SoftmaxLayer{BaseLayer: baseLayer}
 }
+
+var (
+	ErrSplitLayerNoTops = errors.New("split layer needs at least one top")
+)
+
+// SplitLayer hands a bottom blob to several consumers. The tops share the
+// bottom data but keep their own diffs, which are summed into the bottom diff.
+type SplitLayer struct {
+	BaseLayer
+	copyData []bool // tops that are computed on in-place and need their own data
+}
+
+var _ = Layer(new(SplitLayer))
+
+func (l *SplitLayer) Setup(d *LayerData) error {
+	err := l.checkBottomNames(1)
+	if err != nil {
+		return err
+	}
+	if len(l.TopNames) == 0 {
+		return ErrSplitLayerNoTops
+	}
+	if len(l.copyData) != len(l.TopNames) {
+		l.copyData = make([]bool, len(l.TopNames))
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		for i, topName := range l.TopNames {
+			d.Top[i] = NewBlob(topName, &d.Bottom[0].Dim)
+			if !l.copyData[i] {
+				d.Top[i].ShareData(d.Bottom[0])
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *SplitLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	for i, top := range d.Top {
+		if l.copyData[i] {
+			Copy32(bottomData, top.Data.MutableCpuValues(), len(bottomData), 0)
+		}
+	}
+	return 0
+}
+
+func (l *SplitLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	Copy32(d.Top[0].Diff.CpuValues(), bottomDiff, len(bottomDiff), 0)
+	for _, top := range d.Top[1:] {
+		Axpy32(len(bottomDiff), 1, top.Diff.CpuValues(), bottomDiff)
+	}
+}
+
+func NewSplitLayer(baseLayer BaseLayer) *SplitLayer {
+	return &SplitLayer{BaseLayer: baseLayer}
+}
+
+var (
+	ErrInvalidAxis                     = errors.New("invalid axis")
+	ErrEltwiseLayerInvalidInputSize    = errors.New("invalid bottom data: must be at least two of the same size")
+	ErrEltwiseLayerInvalidCoefficients = errors.New("invalid coefficients: need one per bottom for sum")
+	ErrConcatLayerInvalidInputSize     = errors.New("invalid bottom data: must match outside the concat axis")
+	ErrSliceLayerInvalidSlicePoints    = errors.New("invalid slice points for the bottom data")
+)
+
+type EltwiseOp int
+
+const (
+	EltwiseOpProduct EltwiseOp = iota
+	EltwiseOpSum
+	EltwiseOpMax
+)
+
+// EltwiseLayer combines bottoms of the same size element by element. Sum
+// scales each bottom by its coefficient, which all default to 1.
+type EltwiseLayer struct {
+	BaseLayer
+	Operation    EltwiseOp
+	Coefficients []float32
+	maxIndex     *Blob
+}
+
+var _ = Layer(new(EltwiseLayer))
+
+func (l *EltwiseLayer) Setup(d *LayerData) error {
+	err := l.checkTopNames(1)
+	if err != nil {
+		return err
+	}
+	if len(d.Bottom) < 2 {
+		return ErrEltwiseLayerInvalidInputSize
+	}
+	for _, bottom := range d.Bottom[1:] {
+		if bottom.Dim != d.Bottom[0].Dim {
+			return ErrEltwiseLayerInvalidInputSize
+		}
+	}
+	if l.Coefficients == nil {
+		l.Coefficients = make([]float32, len(d.Bottom))
+		Set32(l.Coefficients, 1)
+	}
+	if l.Operation == EltwiseOpSum && len(l.Coefficients) != len(d.Bottom) {
+		return ErrEltwiseLayerInvalidCoefficients
+	}
+	if l.Operation == EltwiseOpMax {
+		l.maxIndex = NewBlob(l.LayerName()+"_maxIndex", &d.Bottom[0].Dim)
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = NewBlob(l.TopNames[0], &d.Bottom[0].Dim)
+	}
+
+	return nil
+}
+
+func (l *EltwiseLayer) FeedForward(d *LayerData) float32 {
+	topData := d.Top[0].Data.MutableCpuValues()
+	count := len(topData)
+
+	switch l.Operation {
+	case EltwiseOpProduct:
+		Copy32(d.Bottom[0].Data.CpuValues(), topData, count, 0)
+		for _, bottom := range d.Bottom[1:] {
+			BinaryApply32(topData, bottom.Data.CpuValues(), Mul32)
+		}
+	case EltwiseOpSum:
+		Set32(topData, 0)
+		for i, bottom := range d.Bottom {
+			Axpy32(count, l.Coefficients[i], bottom.Data.CpuValues(), topData)
+		}
+	case EltwiseOpMax:
+		maxIndex := l.maxIndex.Data.MutableCpuValues()
+		Copy32(d.Bottom[0].Data.CpuValues(), topData, count, 0)
+		Set32(maxIndex, 0)
+		for i, bottom := range d.Bottom[1:] {
+			for j, v := range bottom.Data.CpuValues() {
+				if v > topData[j] {
+					topData[j] = v
+					maxIndex[j] = float32(i + 1)
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func (l *EltwiseLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	topDiff := d.Top[0].Diff.CpuValues()
+
+	for i, bottom := range d.Bottom {
+		bottomDiff := bottom.Diff.MutableCpuValues()
+		switch l.Operation {
+		case EltwiseOpProduct:
+			// product of the other bottoms, avoids dividing by zero bottom data
+			Copy32(topDiff, bottomDiff, len(topDiff), 0)
+			for j, other := range d.Bottom {
+				if j != i {
+					BinaryApply32(bottomDiff, other.Data.CpuValues(), Mul32)
+				}
+			}
+		case EltwiseOpSum:
+			Copy32(topDiff, bottomDiff, len(topDiff), 0)
+			if l.Coefficients[i] != 1 {
+				Scal32(len(bottomDiff), l.Coefficients[i], bottomDiff)
+			}
+		case EltwiseOpMax:
+			maxIndex := l.maxIndex.Data.CpuValues()
+			for j, diff := range topDiff {
+				if int(maxIndex[j]) == i {
+					bottomDiff[j] = diff
+				} else {
+					bottomDiff[j] = 0
+				}
+			}
+		}
+	}
+}
+
+func NewEltwiseLayer(baseLayer BaseLayer, operation EltwiseOp, coefficients []float32) *EltwiseLayer {
+	return &EltwiseLayer{
+		BaseLayer:    baseLayer,
+		Operation:    operation,
+		Coefficients: coefficients,
+	}
+}
+
+// ConcatLayer joins its bottoms along Axis, which all other axes must agree on.
+type ConcatLayer struct {
+	BaseLayer
+	Axis       int
+	numConcats int
+	innerSize  int
+}
+
+var _ = Layer(new(ConcatLayer))
+
+func (l *ConcatLayer) Setup(d *LayerData) error {
+	err := l.checkTopNames(1)
+	if err != nil {
+		return err
+	}
+	if len(d.Bottom) == 0 {
+		return ErrInvalidBottomBlobNames
+	}
+	if l.Axis < 0 || l.Axis >= NumAxes {
+		return ErrInvalidAxis
+	}
+
+	topDim := d.Bottom[0].Dim
+	for _, bottom := range d.Bottom[1:] {
+		bottomDim := bottom.Dim
+		bottomDim.SetAxisSize(l.Axis, topDim.AxisSize(l.Axis))
+		if bottomDim != topDim {
+			return ErrConcatLayerInvalidInputSize
+		}
+		topDim.SetAxisSize(l.Axis, topDim.AxisSize(l.Axis)+bottom.Dim.AxisSize(l.Axis))
+	}
+	l.numConcats = topDim.Count(0, l.Axis)
+	l.innerSize = topDim.Count(l.Axis+1, NumAxes)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = NewBlob(l.TopNames[0], &topDim)
+	}
+
+	return nil
+}
+
+func (l *ConcatLayer) FeedForward(d *LayerData) float32 {
+	topData := d.Top[0].Data.MutableCpuValues()
+	topAxisSize := d.Top[0].Dim.AxisSize(l.Axis) * l.innerSize
+	offset := 0
+	for _, bottom := range d.Bottom {
+		bottomData := bottom.Data.CpuValues()
+		bottomAxisSize := bottom.Dim.AxisSize(l.Axis) * l.innerSize
+		for n := 0; n < l.numConcats; n++ {
+			Copy32(bottomData, topData[n*topAxisSize+offset:], bottomAxisSize, n*bottomAxisSize)
+		}
+		offset += bottomAxisSize
+	}
+	return 0
+}
+
+func (l *ConcatLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	topDiff := d.Top[0].Diff.CpuValues()
+	topAxisSize := d.Top[0].Dim.AxisSize(l.Axis) * l.innerSize
+	offset := 0
+	for _, bottom := range d.Bottom {
+		bottomDiff := bottom.Diff.MutableCpuValues()
+		bottomAxisSize := bottom.Dim.AxisSize(l.Axis) * l.innerSize
+		for n := 0; n < l.numConcats; n++ {
+			Copy32(topDiff, bottomDiff[n*bottomAxisSize:], bottomAxisSize, n*topAxisSize+offset)
+		}
+		offset += bottomAxisSize
+	}
+}
+
+func NewConcatLayer(baseLayer BaseLayer, axis int) *ConcatLayer {
+	return &ConcatLayer{BaseLayer: baseLayer, Axis: axis}
+}
+
+// SliceLayer cuts its bottom along Axis at SlicePoints, one top per slice.
+// Without SlicePoints the bottom is cut into equal parts.
+type SliceLayer struct {
+	BaseLayer
+	Axis        int
+	SlicePoints []int
+	numSlices   int
+	innerSize   int
+}
+
+var _ = Layer(new(SliceLayer))
+
+func (l *SliceLayer) Setup(d *LayerData) error {
+	err := l.checkBottomNames(1)
+	if err != nil {
+		return err
+	}
+	if len(l.TopNames) == 0 {
+		return ErrInvalidTopBlobNames
+	}
+	if l.Axis < 0 || l.Axis >= NumAxes {
+		return ErrInvalidAxis
+	}
+
+	bottomDim := d.Bottom[0].Dim
+	axisSize := bottomDim.AxisSize(l.Axis)
+	sizes := make([]int, len(l.TopNames))
+	if len(l.SlicePoints) == 0 {
+		if axisSize%len(l.TopNames) != 0 {
+			return ErrSliceLayerInvalidSlicePoints
+		}
+		for i := range sizes {
+			sizes[i] = axisSize / len(l.TopNames)
+		}
+	} else {
+		if len(l.SlicePoints) != len(l.TopNames)-1 {
+			return ErrSliceLayerInvalidSlicePoints
+		}
+		points := append(append([]int{}, l.SlicePoints...), axisSize)
+		prev := 0
+		for i, point := range points {
+			sizes[i] = point - prev
+			if sizes[i] <= 0 {
+				return ErrSliceLayerInvalidSlicePoints
+			}
+			prev = point
+		}
+	}
+	l.numSlices = bottomDim.Count(0, l.Axis)
+	l.innerSize = bottomDim.Count(l.Axis+1, NumAxes)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		for i, topName := range l.TopNames {
+			topDim := bottomDim
+			topDim.SetAxisSize(l.Axis, sizes[i])
+			d.Top[i] = NewBlob(topName, &topDim)
+		}
+	}
+
+	return nil
+}
+
+func (l *SliceLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	bottomAxisSize := d.Bottom[0].Dim.AxisSize(l.Axis) * l.innerSize
+	offset := 0
+	for _, top := range d.Top {
+		topData := top.Data.MutableCpuValues()
+		topAxisSize := top.Dim.AxisSize(l.Axis) * l.innerSize
+		for n := 0; n < l.numSlices; n++ {
+			Copy32(bottomData, topData[n*topAxisSize:], topAxisSize, n*bottomAxisSize+offset)
+		}
+		offset += topAxisSize
+	}
+	return 0
+}
+
+func (l *SliceLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	bottomAxisSize := d.Bottom[0].Dim.AxisSize(l.Axis) * l.innerSize
+	offset := 0
+	for _, top := range d.Top {
+		topDiff := top.Diff.CpuValues()
+		topAxisSize := top.Dim.AxisSize(l.Axis) * l.innerSize
+		for n := 0; n < l.numSlices; n++ {
+			Copy32(topDiff, bottomDiff[n*bottomAxisSize+offset:], topAxisSize, n*topAxisSize)
+		}
+		offset += topAxisSize
+	}
+}
+
+func NewSliceLayer(baseLayer BaseLayer, axis int, slicePoints []int) *SliceLayer {
+	return &SliceLayer{
+		BaseLayer:   baseLayer,
+		Axis:        axis,
+		SlicePoints: slicePoints,
+	}
+}
+
+var (
+	ErrReshapeLayerInvalidShape = errors.New("invalid reshape: shape does not match the bottom size")
+	ErrPermuteLayerInvalidOrder = errors.New("invalid permute order: must be a permutation of the axes")
+)
+
+// FlattenLayer reshapes each item of the batch into a single channel vector.
+// The top shares its data and diff with the bottom.
+type FlattenLayer struct {
+	BaseLayer
+}
+
+var _ = Layer(new(FlattenLayer))
+
+func (l *FlattenLayer) Setup(d *LayerData) error {
+	err := l.checkNames(1, 1)
+	if err != nil {
+		return err
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		bottomDim := d.Bottom[0].Dim
+		d.Top[0] = &Blob{Name: l.TopNames[0], Dim: BlobPoint{bottomDim.Batch, bottomDim.BatchSize(), 1, 1}}
+		d.Top[0].ShareData(d.Bottom[0])
+		d.Top[0].ShareDiff(d.Bottom[0])
+	}
+
+	return nil
+}
+
+func (l *FlattenLayer) FeedForward(d *LayerData) float32               { return 0 }
+func (l *FlattenLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+
+func NewFlattenLayer(baseLayer BaseLayer) *FlattenLayer {
+	return &FlattenLayer{BaseLayer: baseLayer}
+}
+
+// ReshapeLayer changes the dimensions of its bottom without copying. In Shape
+// a 0 keeps the size of the bottom axis and a single -1 is inferred from the
+// remaining size.
+type ReshapeLayer struct {
+	BaseLayer
+	Shape []int
+}
+
+var _ = Layer(new(ReshapeLayer))
+
+func (l *ReshapeLayer) Setup(d *LayerData) error {
+	err := l.checkNames(1, 1)
+	if err != nil {
+		return err
+	}
+	if len(l.Shape) != NumAxes {
+		return ErrReshapeLayerInvalidShape
+	}
+
+	bottomDim := d.Bottom[0].Dim
+	shape := make([]int, NumAxes)
+	inferAxis := -1
+	knownSize := 1
+	for axis, size := range l.Shape {
+		switch {
+		case size == 0:
+			shape[axis] = bottomDim.AxisSize(axis)
+		case size == -1 && inferAxis < 0:
+			inferAxis = axis
+			continue
+		case size > 0:
+			shape[axis] = size
+		default:
+			return ErrReshapeLayerInvalidShape
+		}
+		knownSize *= shape[axis]
+	}
+	if inferAxis >= 0 {
+		if knownSize == 0 || bottomDim.Size()%knownSize != 0 {
+			return ErrReshapeLayerInvalidShape
+		}
+		shape[inferAxis] = bottomDim.Size() / knownSize
+	}
+	topDim := BlobPointFromShape(shape)
+	if topDim.Size() != bottomDim.Size() {
+		return ErrReshapeLayerInvalidShape
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = &Blob{Name: l.TopNames[0], Dim: topDim}
+		d.Top[0].ShareData(d.Bottom[0])
+		d.Top[0].ShareDiff(d.Bottom[0])
+	}
+
+	return nil
+}
+
+func (l *ReshapeLayer) FeedForward(d *LayerData) float32               { return 0 }
+func (l *ReshapeLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+
+func NewReshapeLayer(baseLayer BaseLayer, shape []int) *ReshapeLayer {
+	return &ReshapeLayer{BaseLayer: baseLayer, Shape: shape}
+}
+
+// PermuteLayer reorders the axes of its bottom, top axis i is bottom axis
+// Order[i], e.g. {0, 2, 3, 1} turns NCHW into NHWC.
+type PermuteLayer struct {
+	BaseLayer
+	Order         []int
+	bottomStrides []int
+}
+
+var _ = Layer(new(PermuteLayer))
+
+func (l *PermuteLayer) Setup(d *LayerData) error {
+	err := l.checkNames(1, 1)
+	if err != nil {
+		return err
+	}
+	if len(l.Order) != NumAxes {
+		return ErrPermuteLayerInvalidOrder
+	}
+	seen := make([]bool, NumAxes)
+	for _, axis := range l.Order {
+		if axis < 0 || axis >= NumAxes || seen[axis] {
+			return ErrPermuteLayerInvalidOrder
+		}
+		seen[axis] = true
+	}
+
+	bottomDim := d.Bottom[0].Dim
+	bottomShape := bottomDim.Shape()
+	topShape := make([]int, NumAxes)
+	l.bottomStrides = make([]int, NumAxes)
+	for i, axis := range l.Order {
+		topShape[i] = bottomShape[axis]
+		l.bottomStrides[i] = bottomDim.Count(axis+1, NumAxes)
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = NewBlob(l.TopNames[0], &BlobPoint{topShape[0], topShape[1], topShape[2], topShape[3]})
+	}
+
+	return nil
+}
+
+// permute visits every top value with the offset of the matching bottom value.
+func (l *PermuteLayer) permute(topDim BlobPoint, fn func(topIndex, bottomIndex int)) {
+	topIndex := 0
+	for i0 := 0; i0 < topDim.Batch; i0++ {
+		for i1 := 0; i1 < topDim.Channel; i1++ {
+			for i2 := 0; i2 < topDim.Height; i2++ {
+				for i3 := 0; i3 < topDim.Width; i3++ {
+					bottomIndex := i0*l.bottomStrides[0] + i1*l.bottomStrides[1] +
+						i2*l.bottomStrides[2] + i3*l.bottomStrides[3]
+					fn(topIndex, bottomIndex)
+					topIndex++
+				}
+			}
+		}
+	}
+}
+
+func (l *PermuteLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.MutableCpuValues()
+	l.permute(d.Top[0].Dim, func(topIndex, bottomIndex int) {
+		topData[topIndex] = bottomData[bottomIndex]
+	})
+	return 0
+}
+
+func (l *PermuteLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	topDiff := d.Top[0].Diff.CpuValues()
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	l.permute(d.Top[0].Dim, func(topIndex, bottomIndex int) {
+		bottomDiff[bottomIndex] = topDiff[topIndex]
+	})
+}
+
+func NewPermuteLayer(baseLayer BaseLayer, order []int) *PermuteLayer {
+	return &PermuteLayer{BaseLayer: baseLayer, Order: order}
+}
+
+var (
+	ErrEmbeddingLayerInvalidIndex = errors.New("invalid embedding index: must be in [0, vocab size)")
+)
+
+// EmbeddingLayer looks up a row of a (VocabSize x Dim) weight for every index
+// in its bottom, giving a top of (batch, indices per batch item, 1, Dim). The
+// weight diff is sparse: only the rows looked up are written and listed in
+// its DiffRows. The row at PaddingIndex, when HasPadding is set, always gives
+// zeros and receives no gradient. An index outside [0, VocabSize) also gives
+// zeros and no gradient, and sets Err. The weights default to a XavierFiller.
+type EmbeddingLayer struct {
+	BaseLayer
+	VocabSize    int
+	Dim          int
+	HasPadding   bool
+	PaddingIndex int
+	WeightFiller Filler
+	weightParams *Blob
+	numIndices   int
+	err          error
+}
+
+var _ = Layer(new(EmbeddingLayer))
+
+func (l *EmbeddingLayer) Setup(d *LayerData) error {
+	err := l.checkNames(1, 1)
+	if err != nil {
+		return err
+	}
+	if l.HasPadding && (l.PaddingIndex < 0 || l.PaddingIndex >= l.VocabSize) {
+		return ErrEmbeddingLayerInvalidIndex
+	}
+
+	bottomDim := d.Bottom[0].Dim
+	l.numIndices = bottomDim.Size()
+
+	if d.Params == nil {
+		l.weightParams = NewBlob(l.LayerName()+"_weight", &BlobPoint{1, 1, l.VocabSize, l.Dim})
+		d.Params = []*Blob{l.weightParams}
+		fillParam(d, l.weightParams, l.WeightFiller, &XavierFiller{}, l.Dim, l.VocabSize)
+		if l.HasPadding {
+			Set32(Subslice32(l.weightParams.Data.MutableCpuValues(), l.PaddingIndex, l.Dim), 0)
+		}
+	} else {
+		l.weightParams = d.Params[0]
+	}
+	l.weightParams.DiffRows = []int{}
+	l.err = nil
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = NewBlob(l.TopNames[0], &BlobPoint{bottomDim.Batch, bottomDim.BatchSize(), 1, l.Dim})
+	}
+
+	return nil
+}
+
+// index returns the weight row of a bottom value, or false when the value
+// looks up no row.
+func (l *EmbeddingLayer) index(value float32) (int, bool) {
+	index := int(value)
+	if index < 0 || index >= l.VocabSize {
+		if l.err == nil {
+			l.err = ErrEmbeddingLayerInvalidIndex
+		}
+		return 0, false
+	}
+	return index, !l.HasPadding || index != l.PaddingIndex
+}
+
+// Err is the first invalid index looked up since Setup.
+func (l *EmbeddingLayer) Err() error {
+	return l.err
+}
+
+func (l *EmbeddingLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	weightData := l.weightParams.Data.CpuValues()
+	topData := d.Top[0].Data.MutableCpuValues()
+	for i, value := range bottomData {
+		index, ok := l.index(value)
+		topSlice := Subslice32(topData, i, l.Dim)
+		if !ok {
+			Set32(topSlice, 0)
+			continue
+		}
+		Copy32(weightData, topSlice, l.Dim, index*l.Dim)
+	}
+	return 0
+}
+
+func (l *EmbeddingLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	if !paramPropagate {
+		return
+	}
+
+	bottomData := d.Bottom[0].Data.CpuValues()
+	topDiff := d.Top[0].Diff.CpuValues()
+	weightDiff := l.weightParams.Diff.MutableCpuValues()
+
+	// Add to the rows already written since the diffs were last cleared
+	rows := l.weightParams.DiffRows
+	touched := make(map[int]bool, len(rows))
+	for _, row := range rows {
+		touched[row] = true
+	}
+	for i, value := range bottomData {
+		index, ok := l.index(value)
+		if !ok {
+			continue
+		}
+		if !touched[index] {
+			touched[index] = true
+			rows = append(rows, index)
+		}
+		Axpy32(l.Dim, 1, Subslice32(topDiff, i, l.Dim), Subslice32(weightDiff, index, l.Dim))
+	}
+	sort.Ints(rows)
+	l.weightParams.DiffRows = rows
+}
+
+func NewEmbeddingLayer(baseLayer BaseLayer, vocabSize, dim int) *EmbeddingLayer {
+	return &EmbeddingLayer{
+		BaseLayer: baseLayer,
+		VocabSize: vocabSize,
+		Dim:       dim,
+	}
+}
@@ -4,24 +4,193 @@ import (
 	"encoding/binary"
 	"errors"
 	"github.com/boltdb/bolt"
+	"log"
 )
 
 var (
-	ErrFixedLayerInvalidData = errors.New("invalid fixed layer data")
+	ErrFixedLayerInvalidData    = errors.New("invalid fixed layer data")
+	ErrDataLayerNotEnoughInputs = errors.New("invalid data layer: fewer inputs than a batch")
 )
 
+// DataLayer is a layer that reads its tops from a set of inputs, passing over
+// them in epochs.
 type DataLayer interface {
 	Layer
+	// CurrentInputIndex is the position of the next input in the epoch.
 	CurrentInputIndex() int
 	NumInputs() int
+	// Epoch is the number of complete passes over the inputs.
+	Epoch() int
+	// EpochEnded reports whether the last FeedForward completed an epoch.
+	EpochEnded() bool
+	// NumInEpoch is the number of batch items of the last FeedForward that
+	// belong to the epoch it ended, the others are padding or from the next
+	// epoch. It is the full batch when no epoch ended, unless a
+	// StreamDataLayer read no inputs because they were late.
+	NumInEpoch() int
+	// Reset rewinds to the first input of the current epoch.
+	Reset()
+	// Err is the first error reading inputs, after which FeedForward reads
+	// no more.
+	Err() error
 }
 
+// LastBatch selects how a data layer handles an epoch whose inputs do not
+// divide into full batches.
+type LastBatch int
+
+const (
+	// LastBatchWrap fills the last batch with inputs from the next epoch.
+	LastBatchWrap LastBatch = iota
+	// LastBatchDrop skips the inputs that do not fill a batch.
+	LastBatchDrop
+	// LastBatchPad fills the last batch with inputs from the start of the next
+	// epoch, which then still starts from its first input.
+	LastBatchPad
+)
+
+// Batch is a planned batch of a data layer: the input read into each batch
+// item, its random transformation, and the epoch state of the layer after
+// the batch.
+type Batch struct {
+	Inputs     []int
+	Transforms []ItemTransform
+	InputIndex int
+	Epoch      int
+	EpochEnded bool
+	NumInEpoch int
+}
+
+// BatchReader is implemented by data layers that can read batches
+// concurrently. NextBatch plans the next batch and advances the layer past
+// it; ReadBatch, which may run for several batches at once, reads a planned
+// batch into tops shaped like the layer's.
+type BatchReader interface {
+	NextBatch() *Batch
+	ReadBatch(b *Batch, tops []*Blob) error
+}
+
+// inputSampler plans the batches of a data layer over numInputs inputs,
+// tracking its epochs and the first read error. Embedded, it implements the
+// epoch and error methods of DataLayer and the NextBatch method of
+// BatchReader.
+type inputSampler struct {
+	numInBatch  int
+	shuffle     bool
+	lastBatch   LastBatch
+	transformer *DataTransformer
+	random      *RandomSource
+	numInputs   int
+	inputIndex  int
+	order       []int
+	epoch       int
+	epochEnded  bool
+	numInEpoch  int
+	err         error
+}
+
+func (s *inputSampler) setupSampler(numInputs, numInBatch int, shuffle bool, lastBatch LastBatch, transformer *DataTransformer, random *RandomSource) error {
+	if numInputs == 0 || (lastBatch == LastBatchDrop && numInputs < numInBatch) {
+		return ErrDataLayerNotEnoughInputs
+	}
+	*s = inputSampler{
+		numInBatch:  numInBatch,
+		shuffle:     shuffle,
+		lastBatch:   lastBatch,
+		transformer: transformer,
+		random:      random,
+		numInputs:   numInputs,
+	}
+	s.startEpoch()
+	return nil
+}
+
+func (s *inputSampler) startEpoch() {
+	s.inputIndex = 0
+	if s.shuffle {
+		s.order = s.random.Perm(s.numInputs)
+	}
+}
+
+// endEpoch finishes an epoch after numInEpoch items of the current batch.
+func (s *inputSampler) endEpoch(numInEpoch int) {
+	s.epoch++
+	s.epochEnded = true
+	s.numInEpoch = numInEpoch
+	s.startEpoch()
+}
+
+// input is the input at a position in the epoch.
+func (s *inputSampler) input(position int) int {
+	if s.shuffle {
+		return s.order[position]
+	}
+	return position
+}
+
+func (s *inputSampler) NextBatch() *Batch {
+	b := &Batch{Inputs: make([]int, 0, s.numInBatch)}
+	s.epochEnded = false
+	s.numInEpoch = s.numInBatch
+	for n := 0; n < s.numInBatch; n++ {
+		b.Inputs = append(b.Inputs, s.input(s.inputIndex))
+		s.inputIndex++
+		if s.inputIndex < s.numInputs {
+			continue
+		}
+
+		s.endEpoch(n + 1)
+		if s.lastBatch == LastBatchPad {
+			for m := n + 1; m < s.numInBatch; m++ {
+				b.Inputs = append(b.Inputs, s.input((m-n-1)%s.numInputs))
+			}
+			break
+		}
+	}
+
+	if s.lastBatch == LastBatchDrop && !s.epochEnded && s.numInputs-s.inputIndex < s.numInBatch {
+		s.endEpoch(s.numInBatch)
+	}
+
+	if s.transformer != nil {
+		b.Transforms = make([]ItemTransform, len(b.Inputs))
+		for n := range b.Transforms {
+			b.Transforms[n] = s.transformer.Plan(s.random)
+		}
+	}
+	b.InputIndex = s.inputIndex
+	b.Epoch = s.epoch
+	b.EpochEnded = s.epochEnded
+	b.NumInEpoch = s.numInEpoch
+	return b
+}
+
+// Reset keeps the order of the epoch, so a shuffled epoch reads the same
+// inputs again.
+func (s *inputSampler) Reset() {
+	s.inputIndex = 0
+	s.epochEnded = false
+	s.numInEpoch = s.numInBatch
+}
+
+func (s *inputSampler) CurrentInputIndex() int { return s.inputIndex }
+func (s *inputSampler) NumInputs() int         { return s.numInputs }
+func (s *inputSampler) Epoch() int             { return s.epoch }
+func (s *inputSampler) EpochEnded() bool       { return s.epochEnded }
+func (s *inputSampler) NumInEpoch() int        { return s.numInEpoch }
+func (s *inputSampler) Err() error             { return s.err }
+
+// FixedDataLayer feeds Data[i][j] to top i in the j-th FeedForward, each entry
+// being a whole batch of DataDims[i], and wraps around after the last entry.
+// SliceDataLayer batches single inputs instead.
 type FixedDataLayer struct {
 	BaseLayer
 	DataDims   []*BlobPoint
 	Data       [][][]float32
 	numInputs  int
 	inputIndex int
+	epoch      int
+	epochEnded bool
 }
 
 var _ = DataLayer(new(FixedDataLayer))
@@ -40,6 +209,8 @@ func (l *FixedDataLayer) Setup(d *LayerData) error {
 	}
 
 	l.inputIndex = 0
+	l.epoch = 0
+	l.epochEnded = false
 	l.numInputs = len(l.Data[0])
 	if l.numInputs == 0 {
 		return ErrFixedLayerInvalidData
@@ -76,26 +247,54 @@ func (l *FixedDataLayer) FeedForward(d *LayerData) float32 {
 		Copy32(l.Data[i][l.inputIndex], d.Top[i].Data.MutableCpuValues(), l.DataDims[i].Size(), 0)
 	}
 	l.inputIndex++
+	l.epochEnded = l.inputIndex == l.numInputs
+	if l.epochEnded {
+		l.epoch++
+		l.inputIndex = 0
+	}
 	return 0
 }
 
 func (l *FixedDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
 func (l *FixedDataLayer) CurrentInputIndex() int                         { return l.inputIndex }
 func (l *FixedDataLayer) NumInputs() int                                 { return l.numInputs }
+func (l *FixedDataLayer) Epoch() int                                     { return l.epoch }
+func (l *FixedDataLayer) EpochEnded() bool                               { return l.epochEnded }
+func (l *FixedDataLayer) NumInEpoch() int                                { return l.DataDims[0].Batch }
+func (l *FixedDataLayer) Err() error                                     { return nil }
+
+func (l *FixedDataLayer) Reset() {
+	l.inputIndex = 0
+	l.epochEnded = false
+}
 
+// BoltDbDataLayer reads NumInBatch inputs per FeedForward from the buckets
+// named after its tops. With Shuffle the inputs are read in a new random order
+// every epoch. Transformer, if set, preprocesses the inputs of its tops, the
+// others are read as stored. Each bucket decodes by its storage type, so byte
+// and image buckets read without a Transformer are in [0, 255]; Setup warns
+// about those with more than one value per input.
 type BoltDbDataLayer struct {
 	BaseLayer
-	DbFileName string
-	NumInBatch int
-	db         *bolt.DB
-	dims       []*BlobPoint
-	numInputs  int
-	inputIndex int
+	DbFileName  string
+	NumInBatch  int
+	Shuffle     bool
+	LastBatch   LastBatch
+	Transformer *DataTransformer
+	db          *bolt.DB
+	dims        []*BlobPoint
+	types       []string
+	inputSampler
 }
 
 var _ = DataLayer(new(BoltDbDataLayer))
+var _ = BatchReader(new(BoltDbDataLayer))
 
+// intFromBytes decodes a stored int, 0 when it is missing.
 func intFromBytes(p []byte) int {
+	if len(p) < 4 {
+		return 0
+	}
 	return int(binary.LittleEndian.Uint32(p))
 }
 
@@ -105,10 +304,17 @@ func intToBytes(n int) []byte {
 	return p
 }
 
-func (l *BoltDbDataLayer) getBucketDim(db *bolt.DB, bucketName string) (*BlobPoint, error) {
+func (l *BoltDbDataLayer) getBucketDim(db *bolt.DB, bucketName string) (*BlobPoint, string, error) {
 	dim := new(BlobPoint)
+	storageType := StorageByte
 	db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName + "_dim"))
+		if b == nil {
+			return nil
+		}
+		if t := b.Get([]byte("type")); t != nil {
+			storageType = string(t)
+		}
 		dim.Batch = intFromBytes(b.Get([]byte("num")))
 		dim.Channel = intFromBytes(b.Get([]byte("channel")))
 		dim.Height = intFromBytes(b.Get([]byte("height")))
@@ -116,63 +322,148 @@ func (l *BoltDbDataLayer) getBucketDim(db *bolt.DB, bucketName string) (*BlobPoi
 		return nil
 	})
 	if dim.Batch == 0 || dim.Channel == 0 || dim.Height == 0 || dim.Width == 0 {
-		return nil, errors.New("invalid bucket dimension for " + bucketName)
+		return nil, "", errors.New("invalid bucket dimension for " + bucketName)
 	}
-	return dim, nil
+	return dim, storageType, checkStorageType(storageType)
 }
 
 func (l *BoltDbDataLayer) Setup(d *LayerData) (err error) {
+	l.Close()
 	l.db, err = bolt.Open(l.DbFileName, 0400, nil)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			l.Close()
+		}
+	}()
 
+	numInputs := 0
 	l.dims = make([]*BlobPoint, len(l.TopNames))
+	l.types = make([]string, len(l.TopNames))
 	for i, bucketName := range l.TopNames {
-		l.dims[i], err = l.getBucketDim(l.db, bucketName)
+		l.dims[i], l.types[i], err = l.getBucketDim(l.db, bucketName)
 		if err != nil {
 			return err
 		}
-		if i > 0 && l.dims[i].Batch != l.numInputs {
+		if i > 0 && l.dims[i].Batch != numInputs {
 			return errors.New("each bucket needs the same number of inputs")
 		}
-		l.numInputs = l.dims[i].Batch
+		numInputs = l.dims[i].Batch
 		l.dims[i].Batch = l.NumInBatch
 	}
 
+	topDims, err := setupTransformer(l.Transformer, l.TopNames, l.dims)
+	if err != nil {
+		return err
+	}
+	for i, bucketName := range l.TopNames {
+		if l.types[i] != StorageInt32 && l.types[i] != StorageFloat32 && l.dims[i].BatchSize() > 1 &&
+			(l.Transformer == nil || !l.Transformer.Transforms(l.TopNames, i)) {
+			log.Printf("Layer %s: bucket %s is read unscaled in [0, 255], set a Transformer with Scale 1/256 to keep the old scaling\n",
+				l.LayerName(), bucketName)
+		}
+	}
+
 	if d.Top == nil {
 		d.Top = make([]*Blob, len(l.TopNames))
 		for i, topName := range l.TopNames {
-			d.Top[i] = NewBlob(topName, l.dims[i])
+			d.Top[i] = NewBlob(topName, topDims[i])
 		}
 	}
 
-	l.inputIndex = 0
-	return nil
+	return l.setupSampler(numInputs, l.NumInBatch, l.Shuffle, l.LastBatch, l.Transformer, d.RandomSource())
 }
 
-func (l *BoltDbDataLayer) FeedForward(d *LayerData) float32 {
-	for n := 0; n < l.NumInBatch; n++ {
-		inputKey := intToBytes(l.inputIndex)
-		for topIndex, top := range d.Top {
+// ReadBatch reads every input of the batch in a single read transaction. It
+// fails on a missing bucket or a stored value that does not decode to the
+// bucket dimensions.
+func (l *BoltDbDataLayer) ReadBatch(b *Batch, tops []*Blob) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		for topIndex, top := range tops {
+			bucket := tx.Bucket([]byte(l.TopNames[topIndex]))
+			if bucket == nil {
+				return errors.New("missing bucket " + l.TopNames[topIndex])
+			}
 			topData := top.Data.MutableCpuValues()
-			topBucketName := l.TopNames[topIndex]
-
-			topDataSlice := Subslice32(topData, n, l.dims[topIndex].BatchSize())
-			l.db.View(func(tx *bolt.Tx) error {
-				b := tx.Bucket([]byte(topBucketName))
-				d := b.Get(inputKey)
-				for i := 0; i < l.dims[topIndex].BatchSize(); i++ {
-					topDataSlice[i] = float32(d[i]) / float32(256)
+			dim := *l.dims[topIndex]
+			dim.Batch = 1
+			size := dim.Size()
+			transform := l.Transformer != nil && l.Transformer.Transforms(l.TopNames, topIndex)
+			var input []float32
+			if transform {
+				input = make([]float32, size)
+			}
+			for n, inputIndex := range b.Inputs {
+				value := bucket.Get(intToBytes(inputIndex))
+				if !transform {
+					err := DecodeValue(l.types[topIndex], value, &dim, Subslice32(topData, n, size))
+					if err != nil {
+						return err
+					}
+					continue
 				}
-				return nil
-			})
+				err := DecodeValue(l.types[topIndex], value, &dim, input)
+				if err != nil {
+					return err
+				}
+				l.Transformer.Transform(&b.Transforms[n], input, Subslice32(topData, n, top.Dim.BatchSize()))
+			}
+		}
+		return nil
+	})
+}
+
+// setupTransformer sets up transformer for the tops it applies to, which must
+// all have the same dimensions, and returns the dimensions of every top.
+func setupTransformer(transformer *DataTransformer, topNames []string, dims []*BlobPoint) ([]*BlobPoint, error) {
+	if transformer == nil {
+		return dims, nil
+	}
+	topDims := make([]*BlobPoint, len(dims))
+	var inputDim, outputDim *BlobPoint
+	for i, dim := range dims {
+		topDims[i] = dim
+		if !transformer.Transforms(topNames, i) {
+			continue
+		}
+		if inputDim != nil {
+			if *dim != *inputDim {
+				return nil, ErrDataTransformerInvalidTops
+			}
+			topDims[i] = outputDim
+			continue
 		}
-		l.inputIndex = (l.inputIndex + 1) % l.NumInputs()
+		itemDim := *dim
+		itemDim.Batch = 1
+		itemOutputDim, err := transformer.Setup(itemDim)
+		if err != nil {
+			return nil, err
+		}
+		itemOutputDim.Batch = dim.Batch
+		inputDim, outputDim = dim, &itemOutputDim
+		topDims[i] = outputDim
+	}
+	return topDims, nil
+}
+
+func (l *BoltDbDataLayer) FeedForward(d *LayerData) float32 {
+	if l.err == nil {
+		l.err = l.ReadBatch(l.NextBatch(), d.Top)
 	}
 	return 0
 }
 
 func (l *BoltDbDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
-func (l *BoltDbDataLayer) CurrentInputIndex() int                         { return l.inputIndex }
-func (l *BoltDbDataLayer) NumInputs() int                                 { return l.numInputs }
+
+// Close closes the database, the layer can not be used again until the next
+// Setup.
+func (l *BoltDbDataLayer) Close() error {
+	if l.db == nil {
+		return nil
+	}
+	err := l.db.Close()
+	l.db = nil
+	return err
+}
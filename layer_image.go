@@ -0,0 +1,191 @@
+package godnn
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	_ "image/gif"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrImageDataLayerInvalidSource = errors.New("invalid image data layer: source needs lines of a path and a label")
+	ErrImageDataLayerInvalidSize   = errors.New("invalid image data layer: needs 1, 3 or 4 channels")
+)
+
+// ImageDataLayer reads NumInBatch images per FeedForward from the files listed
+// in SourceFile, one "path label" line per image with paths relative to
+// RootDir. Images decode from PNG, JPEG or GIF, resize to Height x Width, by
+// default the size of the first image, and convert to Channels channels: 1
+// for gray, 3 for RGB (the default) or 4 for RGBA, with values in [0, 255].
+// Its tops are the images and, optionally, the labels.
+type ImageDataLayer struct {
+	BaseLayer
+	SourceFile  string
+	RootDir     string
+	NumInBatch  int
+	Height      int
+	Width       int
+	Channels    int
+	Shuffle     bool
+	LastBatch   LastBatch
+	Transformer *DataTransformer
+	fileNames   []string
+	labels      []float32
+	dim         BlobPoint
+	inputSampler
+}
+
+var _ = DataLayer(new(ImageDataLayer))
+var _ = BatchReader(new(ImageDataLayer))
+
+// readImageList reads the "path label" lines of a source file. The path is
+// everything up to the last space, so it may contain spaces itself. Without
+// labels, lines may also hold just a path.
+func readImageList(fileName string, withLabels bool) ([]string, []float32, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var fileNames []string
+	var labels []float32
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.LastIndexAny(line, " \t")
+		label, err := strconv.ParseFloat(line[i+1:], 32)
+		if i < 0 || err != nil {
+			if withLabels {
+				return nil, nil, ErrImageDataLayerInvalidSource
+			}
+			fileNames = append(fileNames, line)
+			continue
+		}
+		fileNames = append(fileNames, strings.TrimSpace(line[:i]))
+		labels = append(labels, float32(label))
+	}
+	return fileNames, labels, scanner.Err()
+}
+
+func (l *ImageDataLayer) decodeImage(index int) (image.Image, error) {
+	f, err := os.Open(filepath.Join(l.RootDir, l.fileNames[index]))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, errors.New(l.fileNames[index] + ": " + err.Error())
+	}
+	return img, nil
+}
+
+func (l *ImageDataLayer) Setup(d *LayerData) (err error) {
+	err = l.checkBottomNames(0)
+	if err != nil {
+		return err
+	}
+	if len(l.TopNames) != 1 && len(l.TopNames) != 2 {
+		return ErrInvalidTopBlobNames
+	}
+	if l.Channels == 0 {
+		l.Channels = 3
+	}
+	if l.Channels != 1 && l.Channels != 3 && l.Channels != 4 {
+		return ErrImageDataLayerInvalidSize
+	}
+
+	l.fileNames, l.labels, err = readImageList(l.SourceFile, len(l.TopNames) == 2)
+	if err != nil {
+		return err
+	}
+	if len(l.fileNames) == 0 {
+		return ErrDataLayerNotEnoughInputs
+	}
+
+	if l.Height == 0 || l.Width == 0 {
+		img, err := l.decodeImage(0)
+		if err != nil {
+			return err
+		}
+		if l.Height == 0 {
+			l.Height = img.Bounds().Dy()
+		}
+		if l.Width == 0 {
+			l.Width = img.Bounds().Dx()
+		}
+	}
+	l.dim = BlobPoint{l.NumInBatch, l.Channels, l.Height, l.Width}
+	dims := []*BlobPoint{&l.dim, {l.NumInBatch, 1, 1, 1}}[:len(l.TopNames)]
+	topDims, err := setupTransformer(l.Transformer, l.TopNames, dims)
+	if err != nil {
+		return err
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		for i, topName := range l.TopNames {
+			d.Top[i] = NewBlob(topName, topDims[i])
+		}
+	}
+
+	return l.setupSampler(len(l.fileNames), l.NumInBatch, l.Shuffle, l.LastBatch, l.Transformer, d.RandomSource())
+}
+
+// ReadBatch decodes the images of the batch. It fails on an image that does
+// not decode.
+func (l *ImageDataLayer) ReadBatch(b *Batch, tops []*Blob) error {
+	itemDim := l.dim
+	itemDim.Batch = 1
+	size := itemDim.Size()
+	transform := l.Transformer != nil && l.Transformer.Transforms(l.TopNames, 0)
+	var input []float32
+	if transform {
+		input = make([]float32, size)
+	}
+
+	imageData := tops[0].Data.MutableCpuValues()
+	for n, index := range b.Inputs {
+		img, err := l.decodeImage(index)
+		if err != nil {
+			return err
+		}
+		if !transform {
+			err = ImageToValues(img, &itemDim, Subslice32(imageData, n, size))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = ImageToValues(img, &itemDim, input)
+		if err != nil {
+			return err
+		}
+		l.Transformer.Transform(&b.Transforms[n], input, Subslice32(imageData, n, tops[0].Dim.BatchSize()))
+	}
+
+	if len(tops) > 1 {
+		labelData := tops[1].Data.MutableCpuValues()
+		for n, index := range b.Inputs {
+			labelData[n] = l.labels[index]
+		}
+	}
+	return nil
+}
+
+func (l *ImageDataLayer) FeedForward(d *LayerData) float32 {
+	if l.err == nil {
+		l.err = l.ReadBatch(l.NextBatch(), d.Top)
+	}
+	return 0
+}
+
+func (l *ImageDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
@@ -4,17 +4,67 @@ import (
 	"errors"
 )
 
+// Loss layers write the loss to a (1,1,1,1) first top. The diff of that top
+// holds the loss weight, which scales the gradient of the loss.
+func newLossBlob(name string) *Blob {
+	b := NewBlob(name, &BlobPoint{1, 1, 1, 1})
+	b.Diff.MutableCpuValues()[0] = 1
+	return b
+}
+
+// minLogisticProb bounds probabilities away from zero before taking logs.
+const minLogisticProb = float32(1e-20)
+
+func lossWeight(d *LayerData) float32 {
+	return d.Top[0].Diff.CpuValues()[0]
+}
+
+var (
+	ErrSoftmaxWithLossLayerInvalidClassWeights = errors.New("invalid class weights: need one per class")
+)
+
+// LossNormalization selects what a loss is divided by.
+type LossNormalization int
+
+const (
+	// LossNormalizationValid divides by the number of labels that are not
+	// ignored, weighted by their class weights.
+	LossNormalizationValid LossNormalization = iota
+	// LossNormalizationFull divides by the number of labels.
+	LossNormalizationFull
+	// LossNormalizationBatchSize divides by the batch size.
+	LossNormalizationBatchSize
+	// LossNormalizationNone does not normalize the loss.
+	LossNormalizationNone
+)
+
+// SoftmaxWithLossLayer is the cross entropy of the softmax of its first
+// bottom for the labels in its second, at every spatial position. Labels equal
+// to IgnoreLabel (when HasIgnoreLabel is set), or outside the classes, add
+// nothing to the loss or the gradient. ClassWeights, one per class of the
+// first bottom, scales the loss of each label by its class, and
+// LabelSmoothing spreads that fraction of each target evenly over all classes.
 type SoftmaxWithLossLayer struct {
 	BaseLayer
+	HasIgnoreLabel bool
+	IgnoreLabel    int
+	ClassWeights   []float32
+	LabelSmoothing float32
+	Normalization  LossNormalization
+
 	softmaxLayer     *SoftmaxLayer
 	softmaxLayerData *LayerData
 	prob             *Blob
 	numBatches       int
+	numClasses       int
 	batchSize        int
 	spatialSize      int
+	normalizer       float32
 }
 
-var _ = Layer(new(SoftmaxWithLossLayer))
+var _ = LossLayer(new(SoftmaxWithLossLayer))
+
+func (l *SoftmaxWithLossLayer) IsLoss() bool { return true }
 
 func (l *SoftmaxWithLossLayer) Setup(d *LayerData) error {
 	err := l.checkNames(2, 2)
@@ -25,43 +75,92 @@ func (l *SoftmaxWithLossLayer) Setup(d *LayerData) error {
 	l.softmaxLayerData = new(LayerData)
 	l.softmaxLayerData.Bottom = []*Blob{d.Bottom[0]}
 	l.softmaxLayer = &SoftmaxLayer{BaseLayer: BaseLayer{
-		l.LayerName() + "_softmax",
-		[]string{d.Bottom[0].Name},
-		[]string{l.LayerName() + "_softmax_prob"},
+		Name:        l.LayerName() + "_softmax",
+		BottomNames: []string{d.Bottom[0].Name},
+		TopNames:    []string{l.LayerName() + "_softmax_prob"},
 	}}
 	l.softmaxLayer.Setup(l.softmaxLayerData)
 
 	l.prob = l.softmaxLayerData.Top[0]
 	probDim := &l.prob.Dim
 	l.numBatches = probDim.Batch
+	l.numClasses = probDim.Channel
 	l.batchSize = probDim.BatchSize()
 	l.spatialSize = probDim.SpatialSize()
+	if l.ClassWeights != nil && len(l.ClassWeights) != l.numClasses {
+		return ErrSoftmaxWithLossLayerInvalidClassWeights
+	}
 
 	if d.Top == nil {
 		d.Top = make([]*Blob, 2)
-		d.Top[0] = NewBlob(l.TopNames[0], &BlobPoint{1, 1, 1, 1})
-		d.Top[0].Diff.MutableCpuValues()[0] = 1 // loss weight
+		d.Top[0] = newLossBlob(l.TopNames[0])
 		d.Top[1] = NewBlob(l.TopNames[1], probDim)
 	}
 
 	return nil
 }
 
+// label returns the label at a batch item and spatial position, and false
+// when it is ignored or not a class.
+func (l *SoftmaxWithLossLayer) label(labelData []float32, batchIndex, spatialIndex int) (int, bool) {
+	label := int(labelData[batchIndex*l.spatialSize+spatialIndex])
+	if l.HasIgnoreLabel && label == l.IgnoreLabel {
+		return label, false
+	}
+	return label, label >= 0 && label < l.numClasses
+}
+
+func (l *SoftmaxWithLossLayer) classWeight(label int) float32 {
+	if l.ClassWeights == nil {
+		return 1
+	}
+	return l.ClassWeights[label]
+}
+
+func (l *SoftmaxWithLossLayer) calculateNormalizer(validCount float32) float32 {
+	switch l.Normalization {
+	case LossNormalizationFull:
+		return float32(l.numBatches * l.spatialSize)
+	case LossNormalizationBatchSize:
+		return float32(l.numBatches)
+	case LossNormalizationNone:
+		return 1
+	}
+	return Max32(validCount, 1)
+}
+
 func (l *SoftmaxWithLossLayer) FeedForward(d *LayerData) float32 {
 	l.softmaxLayer.FeedForward(l.softmaxLayerData)
 	loss := float32(0)
+	validCount := float32(0)
+	smoothing := l.LabelSmoothing / float32(l.numClasses)
 
 	probData := l.prob.Data.CpuValues()
 	labelData := d.Bottom[1].Data.CpuValues()
 	for batchIndex := 0; batchIndex < l.numBatches; batchIndex++ {
+		probSlice := Subslice32(probData, batchIndex, l.batchSize)
 		for spatialIndex := 0; spatialIndex < l.spatialSize; spatialIndex++ {
-			loss -= Log32(Max32(probData[batchIndex*l.batchSize+
-				int(labelData[batchIndex*l.spatialSize+spatialIndex])*l.spatialSize+spatialIndex],
-				0))
+			label, valid := l.label(labelData, batchIndex, spatialIndex)
+			if !valid {
+				continue
+			}
+			weight := l.classWeight(label)
+			validCount += weight
+
+			sampleLoss := -(1 - l.LabelSmoothing) *
+				Log32(Max32(probSlice[label*l.spatialSize+spatialIndex], minLogisticProb))
+			if smoothing != 0 {
+				for c := 0; c < l.numClasses; c++ {
+					sampleLoss -= smoothing * Log32(Max32(probSlice[c*l.spatialSize+spatialIndex], minLogisticProb))
+				}
+			}
+			loss += weight * sampleLoss
 		}
 	}
 
-	d.Top[0].Data.MutableCpuValues()[0] = loss / float32(l.numBatches*l.spatialSize)
+	l.normalizer = l.calculateNormalizer(validCount)
+	loss /= l.normalizer
+	d.Top[0].Data.MutableCpuValues()[0] = loss
 	Copy32(probData, d.Top[1].Data.MutableCpuValues(), len(probData), 0)
 	return loss
 }
@@ -70,20 +169,31 @@ func (l *SoftmaxWithLossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
 	probData := l.prob.Data.CpuValues()
 	labelData := d.Bottom[1].Data.CpuValues()
 	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	smoothing := l.LabelSmoothing / float32(l.numClasses)
 	Copy32(probData, bottomDiff, len(probData), 0)
 	for batchIndex := 0; batchIndex < l.numBatches; batchIndex++ {
+		bottomDiffSlice := Subslice32(bottomDiff, batchIndex, l.batchSize)
 		for spatialIndex := 0; spatialIndex < l.spatialSize; spatialIndex++ {
-			index := batchIndex*l.batchSize +
-				int(labelData[batchIndex*l.spatialSize+spatialIndex])*l.spatialSize + spatialIndex
-			bottomDiff[index] -= 1
+			label, valid := l.label(labelData, batchIndex, spatialIndex)
+			weight := float32(0)
+			if valid {
+				weight = l.classWeight(label)
+			}
+			for c := 0; c < l.numClasses; c++ {
+				index := c*l.spatialSize + spatialIndex
+				if c == label {
+					bottomDiffSlice[index] -= 1 - l.LabelSmoothing
+				}
+				bottomDiffSlice[index] = (bottomDiffSlice[index] - smoothing) * weight
+			}
 		}
 	}
-	lossWeight := d.Top[0].Diff.CpuValues()[0]
-	Scal32(len(bottomDiff), lossWeight/float32(l.numBatches*l.spatialSize), bottomDiff)
+	Scal32(len(bottomDiff), lossWeight(d)/l.normalizer, bottomDiff)
 }
 
 var (
 	ErrSigmoidCrossEntropyLossLayerInvalidInputSize = errors.New("invalid bottom data: must be the same size")
+	ErrLossLayerInvalidInputSize                    = errors.New("invalid bottom data: sizes do not match")
 )
 
 type SigmoidCrossEntropyLossLayer struct {
@@ -94,7 +204,9 @@ type SigmoidCrossEntropyLossLayer struct {
 	bottomSize       int
 }
 
-var _ = Layer(new(SigmoidCrossEntropyLossLayer))
+var _ = LossLayer(new(SigmoidCrossEntropyLossLayer))
+
+func (l *SigmoidCrossEntropyLossLayer) IsLoss() bool { return true }
 
 func (l *SigmoidCrossEntropyLossLayer) Setup(d *LayerData) error {
 	err := l.checkNames(2, 1)
@@ -110,9 +222,9 @@ func (l *SigmoidCrossEntropyLossLayer) Setup(d *LayerData) error {
 	l.sigmoidLayerData = new(LayerData)
 	l.sigmoidLayerData.Bottom = []*Blob{d.Bottom[0]}
 	l.sigmoidLayer = NewSigmoidLayer(BaseLayer{
-		l.LayerName() + "_sigmoid",
-		[]string{d.Bottom[0].Name},
-		[]string{l.LayerName() + "_sigmoid_top"},
+		Name:        l.LayerName() + "_sigmoid",
+		BottomNames: []string{d.Bottom[0].Name},
+		TopNames:    []string{l.LayerName() + "_sigmoid_top"},
 	})
 	l.sigmoidLayer.Setup(l.sigmoidLayerData)
 
@@ -122,7 +234,7 @@ func (l *SigmoidCrossEntropyLossLayer) Setup(d *LayerData) error {
 
 	if d.Top == nil {
 		d.Top = make([]*Blob, 1)
-		d.Top[0] = NewBlob(l.TopNames[0], &BlobPoint{1, 1, 1, 1})
+		d.Top[0] = newLossBlob(l.TopNames[0])
 	}
 
 	return nil
@@ -137,11 +249,12 @@ func (l *SigmoidCrossEntropyLossLayer) FeedForward(d *LayerData) float32 {
 	inputData := d.Bottom[0].Data.CpuValues()
 	targetData := d.Bottom[1].Data.CpuValues()
 	for n := 0; n < l.bottomSize; n++ {
-		loss -= (inputData[n] * (targetData[n] * Pos32(inputData[n]))) -
+		loss -= (inputData[n] * (targetData[n] - Pos32(inputData[n]))) -
 			Log32(1+Exp32(inputData[n]-(2*inputData[n]*Pos32(inputData[n]))))
 	}
 
-	d.Top[0].Data.MutableCpuValues()[0] = loss / float32(l.bottomBatch)
+	loss /= float32(l.bottomBatch)
+	d.Top[0].Data.MutableCpuValues()[0] = loss
 	return loss
 }
 
@@ -150,6 +263,652 @@ func (l *SigmoidCrossEntropyLossLayer) FeedBackward(d *LayerData, paramPropagate
 	targetData := d.Bottom[1].Data.CpuValues()
 	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
 	BinaryEval32(sigmoidOutputData, targetData, bottomDiff, Sub32)
-	loss := d.Top[0].Data.CpuValues()[0]
-	Scal32(l.bottomSize, loss/float32(l.bottomBatch), bottomDiff)
+	Scal32(l.bottomSize, lossWeight(d)/float32(l.bottomBatch), bottomDiff)
+}
+
+// EuclideanLossLayer is the sum of squared differences of its two bottoms
+// over 2N for N batch items.
+type EuclideanLossLayer struct {
+	BaseLayer
+	difference *Blob
+}
+
+var _ = LossLayer(new(EuclideanLossLayer))
+
+func (l *EuclideanLossLayer) IsLoss() bool { return true }
+
+func (l *EuclideanLossLayer) Setup(d *LayerData) error {
+	err := l.checkNames(2, 1)
+	if err != nil {
+		return err
+	}
+	if d.Bottom[0].Dim.Size() != d.Bottom[1].Dim.Size() {
+		return ErrLossLayerInvalidInputSize
+	}
+
+	l.difference = NewBlob(l.LayerName()+"_difference", &d.Bottom[0].Dim)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = newLossBlob(l.TopNames[0])
+	}
+
+	return nil
+}
+
+func (l *EuclideanLossLayer) FeedForward(d *LayerData) float32 {
+	differenceData := l.difference.Data.MutableCpuValues()
+	BinaryEval32(d.Bottom[0].Data.CpuValues(), d.Bottom[1].Data.CpuValues(), differenceData, Sub32)
+	loss := Dot32(len(differenceData), differenceData, 1, differenceData, 1) /
+		float32(2*d.Bottom[0].Dim.Batch)
+	d.Top[0].Data.MutableCpuValues()[0] = loss
+	return loss
+}
+
+func (l *EuclideanLossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	differenceData := l.difference.Data.CpuValues()
+	scale := lossWeight(d) / float32(d.Bottom[0].Dim.Batch)
+	for i, bottom := range d.Bottom {
+		bottomDiff := bottom.Diff.MutableCpuValues()
+		Copy32(differenceData, bottomDiff, len(differenceData), 0)
+		if i == 0 {
+			Scal32(len(bottomDiff), scale, bottomDiff)
+		} else {
+			Scal32(len(bottomDiff), -scale, bottomDiff)
+		}
+	}
+}
+
+func NewEuclideanLossLayer(baseLayer BaseLayer) *EuclideanLossLayer {
+	return &EuclideanLossLayer{BaseLayer: baseLayer}
+}
+
+type HingeNorm int
+
+const (
+	HingeNormL1 HingeNorm = iota
+	HingeNormL2
+)
+
+// HingeLossLayer is the one-vs-all hinge loss of the scores in the first
+// bottom for the labels in the second, squared for HingeNormL2.
+type HingeLossLayer struct {
+	BaseLayer
+	Norm       HingeNorm
+	numClasses int
+	margins    *Blob
+}
+
+var _ = LossLayer(new(HingeLossLayer))
+
+func (l *HingeLossLayer) IsLoss() bool { return true }
+
+func (l *HingeLossLayer) Setup(d *LayerData) error {
+	err := l.checkNames(2, 1)
+	if err != nil {
+		return err
+	}
+	if d.Bottom[0].Dim.Batch != d.Bottom[1].Dim.Size() {
+		return ErrLossLayerInvalidInputSize
+	}
+
+	l.numClasses = d.Bottom[0].Dim.BatchSize()
+	l.margins = NewBlob(l.LayerName()+"_margins", &d.Bottom[0].Dim)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = newLossBlob(l.TopNames[0])
+	}
+
+	return nil
+}
+
+// sign is +1 for the labeled class of batch item n and -1 for every other class.
+func (l *HingeLossLayer) sign(labelData []float32, n, class int) float32 {
+	if int(labelData[n]) == class {
+		return 1
+	}
+	return -1
+}
+
+func (l *HingeLossLayer) FeedForward(d *LayerData) float32 {
+	scoreData := d.Bottom[0].Data.CpuValues()
+	labelData := d.Bottom[1].Data.CpuValues()
+	marginData := l.margins.Data.MutableCpuValues()
+	loss := float32(0)
+	for n := 0; n < d.Bottom[0].Dim.Batch; n++ {
+		for c := 0; c < l.numClasses; c++ {
+			i := n*l.numClasses + c
+			marginData[i] = Max32(0, 1-l.sign(labelData, n, c)*scoreData[i])
+			switch l.Norm {
+			case HingeNormL1:
+				loss += marginData[i]
+			case HingeNormL2:
+				loss += Sq32(marginData[i])
+			}
+		}
+	}
+	loss /= float32(d.Bottom[0].Dim.Batch)
+	d.Top[0].Data.MutableCpuValues()[0] = loss
+	return loss
+}
+
+func (l *HingeLossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	labelData := d.Bottom[1].Data.CpuValues()
+	marginData := l.margins.Data.CpuValues()
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	scale := lossWeight(d) / float32(d.Bottom[0].Dim.Batch)
+	for n := 0; n < d.Bottom[0].Dim.Batch; n++ {
+		for c := 0; c < l.numClasses; c++ {
+			i := n*l.numClasses + c
+			sign := l.sign(labelData, n, c)
+			switch l.Norm {
+			case HingeNormL1:
+				bottomDiff[i] = -sign * Pos32(marginData[i]-1e-20) * scale
+			case HingeNormL2:
+				bottomDiff[i] = -2 * sign * marginData[i] * scale
+			}
+		}
+	}
+}
+
+func NewHingeLossLayer(baseLayer BaseLayer, norm HingeNorm) *HingeLossLayer {
+	return &HingeLossLayer{BaseLayer: baseLayer, Norm: norm}
+}
+
+// ContrastiveLossLayer pulls the feature pairs in its first two bottoms
+// together when the third bottom is 1 and pushes them at least Margin apart
+// when it is 0. Margin defaults to 1.
+type ContrastiveLossLayer struct {
+	BaseLayer
+	Margin      float32
+	featureSize int
+	difference  *Blob
+	distances   []float32
+}
+
+var _ = LossLayer(new(ContrastiveLossLayer))
+
+func (l *ContrastiveLossLayer) IsLoss() bool { return true }
+
+func (l *ContrastiveLossLayer) Setup(d *LayerData) error {
+	err := l.checkNames(3, 1)
+	if err != nil {
+		return err
+	}
+	if d.Bottom[0].Dim != d.Bottom[1].Dim || d.Bottom[0].Dim.Batch != d.Bottom[2].Dim.Size() {
+		return ErrLossLayerInvalidInputSize
+	}
+	if l.Margin == 0 {
+		l.Margin = 1
+	}
+
+	l.featureSize = d.Bottom[0].Dim.BatchSize()
+	l.difference = NewBlob(l.LayerName()+"_difference", &d.Bottom[0].Dim)
+	l.distances = make([]float32, d.Bottom[0].Dim.Batch)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = newLossBlob(l.TopNames[0])
+	}
+
+	return nil
+}
+
+func (l *ContrastiveLossLayer) FeedForward(d *LayerData) float32 {
+	differenceData := l.difference.Data.MutableCpuValues()
+	similarData := d.Bottom[2].Data.CpuValues()
+	BinaryEval32(d.Bottom[0].Data.CpuValues(), d.Bottom[1].Data.CpuValues(), differenceData, Sub32)
+	loss := float32(0)
+	for n := range l.distances {
+		differenceSlice := Subslice32(differenceData, n, l.featureSize)
+		squaredDistance := Dot32(l.featureSize, differenceSlice, 1, differenceSlice, 1)
+		l.distances[n] = Sqrt32(squaredDistance)
+		if similarData[n] != 0 {
+			loss += squaredDistance
+		} else {
+			loss += Sq32(Max32(l.Margin-l.distances[n], 0))
+		}
+	}
+	loss /= float32(2 * len(l.distances))
+	d.Top[0].Data.MutableCpuValues()[0] = loss
+	return loss
+}
+
+func (l *ContrastiveLossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	differenceData := l.difference.Data.CpuValues()
+	similarData := d.Bottom[2].Data.CpuValues()
+	for i, bottom := range d.Bottom[:2] {
+		bottomDiff := bottom.Diff.MutableCpuValues()
+		scale := lossWeight(d) / float32(len(l.distances))
+		if i == 1 {
+			scale = -scale
+		}
+		for n, distance := range l.distances {
+			differenceSlice := Subslice32(differenceData, n, l.featureSize)
+			bottomDiffSlice := Subslice32(bottomDiff, n, l.featureSize)
+			Copy32(differenceSlice, bottomDiffSlice, l.featureSize, 0)
+			if similarData[n] != 0 {
+				Scal32(l.featureSize, scale, bottomDiffSlice)
+			} else if margin := l.Margin - distance; margin > 0 {
+				Scal32(l.featureSize, -scale*margin/(distance+1e-4), bottomDiffSlice)
+			} else {
+				Set32(bottomDiffSlice, 0)
+			}
+		}
+	}
+}
+
+func NewContrastiveLossLayer(baseLayer BaseLayer, margin float32) *ContrastiveLossLayer {
+	return &ContrastiveLossLayer{BaseLayer: baseLayer, Margin: margin}
+}
+
+// TripletLossLayer requires the anchor in its first bottom to be closer, by
+// squared distance, to the positive in its second bottom than to the
+// negative in its third by at least Margin, which defaults to 1.
+type TripletLossLayer struct {
+	BaseLayer
+	Margin      float32
+	featureSize int
+	losses      []float32
+}
+
+var _ = LossLayer(new(TripletLossLayer))
+
+func (l *TripletLossLayer) IsLoss() bool { return true }
+
+func (l *TripletLossLayer) Setup(d *LayerData) error {
+	err := l.checkNames(3, 1)
+	if err != nil {
+		return err
+	}
+	if d.Bottom[0].Dim != d.Bottom[1].Dim || d.Bottom[0].Dim != d.Bottom[2].Dim {
+		return ErrLossLayerInvalidInputSize
+	}
+	if l.Margin == 0 {
+		l.Margin = 1
+	}
+
+	l.featureSize = d.Bottom[0].Dim.BatchSize()
+	l.losses = make([]float32, d.Bottom[0].Dim.Batch)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = newLossBlob(l.TopNames[0])
+	}
+
+	return nil
+}
+
+func (l *TripletLossLayer) FeedForward(d *LayerData) float32 {
+	anchorData := d.Bottom[0].Data.CpuValues()
+	positiveData := d.Bottom[1].Data.CpuValues()
+	negativeData := d.Bottom[2].Data.CpuValues()
+	loss := float32(0)
+	for n := range l.losses {
+		positiveDistance := float32(0)
+		negativeDistance := float32(0)
+		for i := n * l.featureSize; i < (n+1)*l.featureSize; i++ {
+			positiveDistance += Sq32(anchorData[i] - positiveData[i])
+			negativeDistance += Sq32(anchorData[i] - negativeData[i])
+		}
+		l.losses[n] = Max32(positiveDistance-negativeDistance+l.Margin, 0)
+		loss += l.losses[n]
+	}
+	loss /= float32(len(l.losses))
+	d.Top[0].Data.MutableCpuValues()[0] = loss
+	return loss
+}
+
+func (l *TripletLossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	anchorData := d.Bottom[0].Data.CpuValues()
+	positiveData := d.Bottom[1].Data.CpuValues()
+	negativeData := d.Bottom[2].Data.CpuValues()
+	anchorDiff := d.Bottom[0].Diff.MutableCpuValues()
+	positiveDiff := d.Bottom[1].Diff.MutableCpuValues()
+	negativeDiff := d.Bottom[2].Diff.MutableCpuValues()
+	scale := 2 * lossWeight(d) / float32(len(l.losses))
+	for n, loss := range l.losses {
+		for i := n * l.featureSize; i < (n+1)*l.featureSize; i++ {
+			if loss > 0 {
+				anchorDiff[i] = scale * (negativeData[i] - positiveData[i])
+				positiveDiff[i] = scale * (positiveData[i] - anchorData[i])
+				negativeDiff[i] = scale * (anchorData[i] - negativeData[i])
+			} else {
+				anchorDiff[i] = 0
+				positiveDiff[i] = 0
+				negativeDiff[i] = 0
+			}
+		}
+	}
+}
+
+func NewTripletLossLayer(baseLayer BaseLayer, margin float32) *TripletLossLayer {
+	return &TripletLossLayer{BaseLayer: baseLayer, Margin: margin}
+}
+
+// MultinomialLogisticLossLayer is the negative log of the probability in the
+// first bottom of the label in the second, e.g. after a SoftmaxLayer.
+type MultinomialLogisticLossLayer struct {
+	BaseLayer
+	numClasses int
+}
+
+var _ = LossLayer(new(MultinomialLogisticLossLayer))
+
+func (l *MultinomialLogisticLossLayer) IsLoss() bool { return true }
+
+func (l *MultinomialLogisticLossLayer) Setup(d *LayerData) error {
+	err := l.checkNames(2, 1)
+	if err != nil {
+		return err
+	}
+	if d.Bottom[0].Dim.Batch != d.Bottom[1].Dim.Size() {
+		return ErrLossLayerInvalidInputSize
+	}
+
+	l.numClasses = d.Bottom[0].Dim.BatchSize()
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = newLossBlob(l.TopNames[0])
+	}
+
+	return nil
+}
+
+func (l *MultinomialLogisticLossLayer) FeedForward(d *LayerData) float32 {
+	probData := d.Bottom[0].Data.CpuValues()
+	labelData := d.Bottom[1].Data.CpuValues()
+	loss := float32(0)
+	for n, label := range labelData {
+		loss -= Log32(Max32(probData[n*l.numClasses+int(label)], minLogisticProb))
+	}
+	loss /= float32(len(labelData))
+	d.Top[0].Data.MutableCpuValues()[0] = loss
+	return loss
+}
+
+func (l *MultinomialLogisticLossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	probData := d.Bottom[0].Data.CpuValues()
+	labelData := d.Bottom[1].Data.CpuValues()
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	Set32(bottomDiff, 0)
+	scale := -lossWeight(d) / float32(len(labelData))
+	for n, label := range labelData {
+		index := n*l.numClasses + int(label)
+		bottomDiff[index] = scale / Max32(probData[index], minLogisticProb)
+	}
+}
+
+func NewMultinomialLogisticLossLayer(baseLayer BaseLayer) *MultinomialLogisticLossLayer {
+	return &MultinomialLogisticLossLayer{BaseLayer: baseLayer}
+}
+
+// SmoothL1LossLayer is the smooth L1 loss of the difference of its two
+// bottoms over the batch size: quadratic below 1/Sigma^2 and linear above.
+// Sigma defaults to 1.
+type SmoothL1LossLayer struct {
+	BaseLayer
+	Sigma      float32
+	difference *Blob
+}
+
+var _ = LossLayer(new(SmoothL1LossLayer))
+
+func (l *SmoothL1LossLayer) IsLoss() bool { return true }
+
+func (l *SmoothL1LossLayer) Setup(d *LayerData) error {
+	err := l.checkNames(2, 1)
+	if err != nil {
+		return err
+	}
+	if d.Bottom[0].Dim.Size() != d.Bottom[1].Dim.Size() {
+		return ErrLossLayerInvalidInputSize
+	}
+	if l.Sigma == 0 {
+		l.Sigma = 1
+	}
+
+	l.difference = NewBlob(l.LayerName()+"_difference", &d.Bottom[0].Dim)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = newLossBlob(l.TopNames[0])
+	}
+
+	return nil
+}
+
+func (l *SmoothL1LossLayer) FeedForward(d *LayerData) float32 {
+	differenceData := l.difference.Data.MutableCpuValues()
+	BinaryEval32(d.Bottom[0].Data.CpuValues(), d.Bottom[1].Data.CpuValues(), differenceData, Sub32)
+	sigma2 := Sq32(l.Sigma)
+	loss := float32(0)
+	for _, x := range differenceData {
+		if Abs32(x) < 1/sigma2 {
+			loss += 0.5 * sigma2 * Sq32(x)
+		} else {
+			loss += Abs32(x) - 0.5/sigma2
+		}
+	}
+	loss /= float32(d.Bottom[0].Dim.Batch)
+	d.Top[0].Data.MutableCpuValues()[0] = loss
+	return loss
+}
+
+func (l *SmoothL1LossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	differenceData := l.difference.Data.CpuValues()
+	sigma2 := Sq32(l.Sigma)
+	scale := lossWeight(d) / float32(d.Bottom[0].Dim.Batch)
+	for i, bottom := range d.Bottom {
+		if i == 1 {
+			scale = -scale
+		}
+		bottomDiff := bottom.Diff.MutableCpuValues()
+		for j, x := range differenceData {
+			if Abs32(x) < 1/sigma2 {
+				bottomDiff[j] = scale * sigma2 * x
+			} else {
+				bottomDiff[j] = scale * Sign32(x)
+			}
+		}
+	}
+}
+
+func NewSmoothL1LossLayer(baseLayer BaseLayer, sigma float32) *SmoothL1LossLayer {
+	return &SmoothL1LossLayer{BaseLayer: baseLayer, Sigma: sigma}
+}
+
+// HuberLossLayer is the Huber loss of the difference of its two bottoms over
+// the batch size: quadratic up to Delta and linear above. Delta defaults to 1.
+type HuberLossLayer struct {
+	BaseLayer
+	Delta      float32
+	difference *Blob
+}
+
+var _ = LossLayer(new(HuberLossLayer))
+
+func (l *HuberLossLayer) IsLoss() bool { return true }
+
+func (l *HuberLossLayer) Setup(d *LayerData) error {
+	err := l.checkNames(2, 1)
+	if err != nil {
+		return err
+	}
+	if d.Bottom[0].Dim.Size() != d.Bottom[1].Dim.Size() {
+		return ErrLossLayerInvalidInputSize
+	}
+	if l.Delta == 0 {
+		l.Delta = 1
+	}
+
+	l.difference = NewBlob(l.LayerName()+"_difference", &d.Bottom[0].Dim)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = newLossBlob(l.TopNames[0])
+	}
+
+	return nil
+}
+
+func (l *HuberLossLayer) FeedForward(d *LayerData) float32 {
+	differenceData := l.difference.Data.MutableCpuValues()
+	BinaryEval32(d.Bottom[0].Data.CpuValues(), d.Bottom[1].Data.CpuValues(), differenceData, Sub32)
+	loss := float32(0)
+	for _, x := range differenceData {
+		if Abs32(x) <= l.Delta {
+			loss += 0.5 * Sq32(x)
+		} else {
+			loss += l.Delta * (Abs32(x) - 0.5*l.Delta)
+		}
+	}
+	loss /= float32(d.Bottom[0].Dim.Batch)
+	d.Top[0].Data.MutableCpuValues()[0] = loss
+	return loss
+}
+
+func (l *HuberLossLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	differenceData := l.difference.Data.CpuValues()
+	scale := lossWeight(d) / float32(d.Bottom[0].Dim.Batch)
+	for i, bottom := range d.Bottom {
+		if i == 1 {
+			scale = -scale
+		}
+		bottomDiff := bottom.Diff.MutableCpuValues()
+		for j, x := range differenceData {
+			if Abs32(x) <= l.Delta {
+				bottomDiff[j] = scale * x
+			} else {
+				bottomDiff[j] = scale * l.Delta * Sign32(x)
+			}
+		}
+	}
+}
+
+func NewHuberLossLayer(baseLayer BaseLayer, delta float32) *HuberLossLayer {
+	return &HuberLossLayer{BaseLayer: baseLayer, Delta: delta}
+}
+
+var (
+	ErrAccuracyLayerInvalidTopK   = errors.New("invalid top k: must be at most the number of classes")
+	ErrAccuracyLayerInvalidLabels = errors.New("invalid bottom labels: need one per spatial position")
+)
+
+// AccuracyLayer is the fraction of labels in its second bottom that are
+// among the TopK highest scores of its first bottom, at every spatial
+// position. Labels equal to IgnoreLabel (when HasIgnoreLabel is set), or
+// outside the classes, are not counted. An optional second top holds the
+// accuracy of each class.
+type AccuracyLayer struct {
+	BaseLayer
+	TopK           int
+	HasIgnoreLabel bool
+	IgnoreLabel    int
+
+	numBatches  int
+	numClasses  int
+	spatialSize int
+	classCounts []int
+}
+
+var _ = Layer(new(AccuracyLayer))
+
+func (l *AccuracyLayer) Setup(d *LayerData) error {
+	if len(l.TopNames) != 1 && len(l.TopNames) != 2 {
+		return ErrInvalidTopBlobNames
+	}
+	err := l.checkBottomNames(2)
+	if err != nil {
+		return err
+	}
+	if l.TopK == 0 {
+		l.TopK = 1
+	}
+
+	dim := &d.Bottom[0].Dim
+	l.numBatches = dim.Batch
+	l.numClasses = dim.Channel
+	l.spatialSize = dim.SpatialSize()
+	if l.TopK > l.numClasses {
+		return ErrAccuracyLayerInvalidTopK
+	}
+	if d.Bottom[1].Dim.Size() != l.numBatches*l.spatialSize {
+		return ErrAccuracyLayerInvalidLabels
+	}
+	l.classCounts = make([]int, l.numClasses)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		d.Top[0] = NewBlob(l.TopNames[0], &BlobPoint{1, 1, 1, 1})
+		if len(l.TopNames) == 2 {
+			d.Top[1] = NewBlob(l.TopNames[1], &BlobPoint{1, l.numClasses, 1, 1})
+		}
+	}
+
+	return nil
+}
+
+func (l *AccuracyLayer) FeedForward(d *LayerData) float32 {
+	scoreData := d.Bottom[0].Data.CpuValues()
+	labelData := d.Bottom[1].Data.CpuValues()
+	var classAccuracy []float32
+	if len(d.Top) == 2 {
+		classAccuracy = d.Top[1].Data.MutableCpuValues()
+		Set32(classAccuracy, 0)
+	}
+	for c := range l.classCounts {
+		l.classCounts[c] = 0
+	}
+
+	correct, count := 0, 0
+	for batchIndex := 0; batchIndex < l.numBatches; batchIndex++ {
+		scoreSlice := Subslice32(scoreData, batchIndex, l.numClasses*l.spatialSize)
+		for spatialIndex := 0; spatialIndex < l.spatialSize; spatialIndex++ {
+			label := int(labelData[batchIndex*l.spatialSize+spatialIndex])
+			if l.HasIgnoreLabel && label == l.IgnoreLabel {
+				continue
+			}
+			if label < 0 || label >= l.numClasses {
+				continue
+			}
+			count++
+			l.classCounts[label]++
+
+			// the label is in the top k when fewer than k classes score higher
+			labelScore := scoreSlice[label*l.spatialSize+spatialIndex]
+			higher := 0
+			for c := 0; c < l.numClasses && higher < l.TopK; c++ {
+				if scoreSlice[c*l.spatialSize+spatialIndex] > labelScore {
+					higher++
+				}
+			}
+			if higher < l.TopK {
+				correct++
+				if classAccuracy != nil {
+					classAccuracy[label]++
+				}
+			}
+		}
+	}
+
+	accuracy := float32(0)
+	if count > 0 {
+		accuracy = float32(correct) / float32(count)
+	}
+	d.Top[0].Data.MutableCpuValues()[0] = accuracy
+	for c := range classAccuracy {
+		if l.classCounts[c] > 0 {
+			classAccuracy[c] /= float32(l.classCounts[c])
+		}
+	}
+	return 0
+}
+
+func (l *AccuracyLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+
+func NewAccuracyLayer(baseLayer BaseLayer, topK int) *AccuracyLayer {
+	return &AccuracyLayer{BaseLayer: baseLayer, TopK: topK}
 }
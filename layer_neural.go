@@ -1,5 +1,9 @@
 package godnn
 
+import (
+	"errors"
+)
+
 type NeuronLayer struct {
 	BaseLayer
 	f ActivationFn
@@ -15,7 +19,11 @@ func (l *NeuronLayer) Setup(d *LayerData) error {
 
 	if d.Top == nil {
 		d.Top = make([]*Blob, 1)
-		d.Top[0] = NewBlob(l.TopNames[0], &d.Bottom[0].Dim)
+		if l.inPlace(0, 0) {
+			d.Top[0] = d.Bottom[0]
+		} else {
+			d.Top[0] = NewBlob(l.TopNames[0], &d.Bottom[0].Dim)
+		}
 	}
 
 	return nil
@@ -30,12 +38,14 @@ func (l *NeuronLayer) FeedForward(d *LayerData) float32 {
 	return 0
 }
 
+// FeedBackward computes the derivative from the top data so that the layer
+// can be run in-place, when the bottom data has already been overwritten.
 func (l *NeuronLayer) FeedBackward(d *LayerData, paramPropagate bool) {
 	topDiff := d.Top[0].Diff.CpuValues()
-	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.CpuValues()
 	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
 	for i, diff := range topDiff {
-		bottomDiff[i] = diff * l.f.FirstDeriv(bottomData[i])
+		bottomDiff[i] = diff * l.f.FirstDeriv(topData[i])
 	}
 }
 
@@ -70,7 +80,11 @@ func (l *ReLULayer) Setup(d *LayerData) error {
 
 	if d.Top == nil {
 		d.Top = make([]*Blob, 1)
-		d.Top[0] = NewBlob(l.TopNames[0], &d.Bottom[0].Dim)
+		if l.inPlace(0, 0) {
+			d.Top[0] = d.Bottom[0]
+		} else {
+			d.Top[0] = NewBlob(l.TopNames[0], &d.Bottom[0].Dim)
+		}
 	}
 
 	return nil
@@ -86,16 +100,18 @@ func (l *ReLULayer) FeedForward(d *LayerData) float32 {
 	return 0
 }
 
+// FeedBackward uses the sign of the top data, which matches the sign of the
+// bottom data for a non-negative slope, so that the layer can be run in-place.
 func (l *ReLULayer) FeedBackward(d *LayerData, paramPropagate bool) {
 	topDiff := d.Top[0].Diff.CpuValues()
-	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.CpuValues()
 	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
 	negativeSlope := l.NegativeSlope
 	for i, diff := range topDiff {
-		if bottomData[i] >= 0 {
+		if topData[i] > 0 {
 			bottomDiff[i] = diff
 		} else {
-			bottomDiff[i] = negativeSlope
+			bottomDiff[i] = diff * negativeSlope
 		}
 	}
 }
@@ -103,3 +119,84 @@ func (l *ReLULayer) FeedBackward(d *LayerData, paramPropagate bool) {
 func NewReLULayer(baseLayer BaseLayer, negativeSlope float32) *ReLULayer {
 	return &ReLULayer{baseLayer, negativeSlope}
 }
+
+var (
+	ErrDropoutLayerInvalidRatio = errors.New("invalid dropout ratio: must be in [0, 1)")
+)
+
+type DropoutLayer struct {
+	BaseLayer
+	Ratio float32
+	Phase Phase
+	scale float32
+	mask  *Blob
+}
+
+var _ = Layer(new(DropoutLayer))
+
+func (l *DropoutLayer) Setup(d *LayerData) error {
+	err := l.checkNames(1, 1)
+	if err != nil {
+		return err
+	}
+	if l.Ratio < 0 || l.Ratio >= 1 {
+		return ErrDropoutLayerInvalidRatio
+	}
+
+	l.scale = 1 / (1 - l.Ratio)
+	l.mask = NewBlob(l.LayerName()+"_mask", &d.Bottom[0].Dim)
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		if l.inPlace(0, 0) {
+			d.Top[0] = d.Bottom[0]
+		} else {
+			d.Top[0] = NewBlob(l.TopNames[0], &d.Bottom[0].Dim)
+		}
+	}
+
+	return nil
+}
+
+func (l *DropoutLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.MutableCpuValues()
+	if l.Phase != PhaseTrain {
+		if d.Top[0] != d.Bottom[0] {
+			Copy32(bottomData, topData, len(bottomData), 0)
+		}
+		return 0
+	}
+
+	random := d.RandomSource()
+	maskData := l.mask.Data.MutableCpuValues()
+	for i, v := range bottomData {
+		if random.Float32() >= l.Ratio {
+			maskData[i] = l.scale
+		} else {
+			maskData[i] = 0
+		}
+		topData[i] = v * maskData[i]
+	}
+	return 0
+}
+
+func (l *DropoutLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	topDiff := d.Top[0].Diff.CpuValues()
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	if l.Phase != PhaseTrain {
+		if d.Top[0] != d.Bottom[0] {
+			Copy32(topDiff, bottomDiff, len(topDiff), 0)
+		}
+		return
+	}
+
+	maskData := l.mask.Data.CpuValues()
+	for i, diff := range topDiff {
+		bottomDiff[i] = diff * maskData[i]
+	}
+}
+
+func NewDropoutLayer(baseLayer BaseLayer, ratio float32, phase Phase) *DropoutLayer {
+	return &DropoutLayer{BaseLayer: baseLayer, Ratio: ratio, Phase: phase}
+}
@@ -0,0 +1,253 @@
+package godnn
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	ErrNpyDataLayerInvalidArrays = errors.New("invalid npy data layer: needs one array per top, each with the same number of items")
+)
+
+// npyArray is a .npy file mapped into memory, read an item along its first
+// axis at a time.
+type npyArray struct {
+	header    *NpyHeader
+	itemDim   BlobPoint
+	itemBytes int
+	numItems  int
+	mapped    []byte
+	data      []byte
+	unmap     func([]byte) error
+}
+
+func openNpyArray(fileName string) (*npyArray, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, offset, err := ReadNpyHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.Shape) == 0 {
+		return nil, ErrNpyDataLayerInvalidArrays
+	}
+	itemDim, err := h.ItemDim()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &npyArray{header: h, itemDim: itemDim, itemBytes: itemDim.Size() * h.ItemSize(), numItems: h.Shape[0]}
+	size := offset + a.numItems*a.itemBytes
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < int64(size) {
+		return nil, ErrNpyInvalidData
+	}
+	a.mapped, a.unmap, err = mapFile(f, size)
+	if err != nil {
+		return nil, err
+	}
+	a.data = a.mapped[offset:]
+	return a, nil
+}
+
+func (a *npyArray) read(item int, data []float32) error {
+	return DecodeNpy(a.header, a.data[item*a.itemBytes:(item+1)*a.itemBytes], data)
+}
+
+func (a *npyArray) close() error {
+	if a.mapped == nil {
+		return nil
+	}
+	err := a.unmap(a.mapped)
+	a.mapped, a.data = nil, nil
+	return err
+}
+
+// NpyDataLayer reads NumInBatch items per FeedForward from the NumPy .npy
+// files FileNames, one per top, which it maps into memory rather than reading.
+// Items run along the first axis of each array, with the remaining axes
+// aligned to (channel, height, width) from the right. With Shuffle the items
+// are read in a new random order every epoch. Transformer, if set,
+// preprocesses the items of its tops. HDF5 files need converting to .npy
+// first.
+type NpyDataLayer struct {
+	BaseLayer
+	FileNames   []string
+	NumInBatch  int
+	Shuffle     bool
+	LastBatch   LastBatch
+	Transformer *DataTransformer
+	arrays      []*npyArray
+	inputSampler
+}
+
+var _ = DataLayer(new(NpyDataLayer))
+var _ = BatchReader(new(NpyDataLayer))
+
+func (l *NpyDataLayer) Setup(d *LayerData) error {
+	err := l.checkNames(0, len(l.FileNames))
+	if err != nil {
+		return err
+	}
+	if len(l.FileNames) == 0 {
+		return ErrNpyDataLayerInvalidArrays
+	}
+
+	l.Close()
+	dims := make([]*BlobPoint, len(l.FileNames))
+	for i, fileName := range l.FileNames {
+		a, err := openNpyArray(fileName)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		l.arrays = append(l.arrays, a)
+		if a.numItems != l.arrays[0].numItems {
+			l.Close()
+			return ErrNpyDataLayerInvalidArrays
+		}
+		dims[i] = &BlobPoint{l.NumInBatch, a.itemDim.Channel, a.itemDim.Height, a.itemDim.Width}
+	}
+
+	topDims, err := setupTransformer(l.Transformer, l.TopNames, dims)
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		for i, topName := range l.TopNames {
+			d.Top[i] = NewBlob(topName, topDims[i])
+		}
+	}
+
+	return l.setupSampler(l.arrays[0].numItems, l.NumInBatch, l.Shuffle, l.LastBatch, l.Transformer, d.RandomSource())
+}
+
+// ReadBatch decodes the items of the batch from the mapped arrays.
+func (l *NpyDataLayer) ReadBatch(b *Batch, tops []*Blob) error {
+	for topIndex, top := range tops {
+		a := l.arrays[topIndex]
+		topData := top.Data.MutableCpuValues()
+		size := a.itemDim.Size()
+		transform := l.Transformer != nil && l.Transformer.Transforms(l.TopNames, topIndex)
+		var input []float32
+		if transform {
+			input = make([]float32, size)
+		}
+		for n, item := range b.Inputs {
+			if !transform {
+				a.read(item, Subslice32(topData, n, size))
+				continue
+			}
+			a.read(item, input)
+			l.Transformer.Transform(&b.Transforms[n], input, Subslice32(topData, n, top.Dim.BatchSize()))
+		}
+	}
+	return nil
+}
+
+func (l *NpyDataLayer) FeedForward(d *LayerData) float32 {
+	if l.err == nil {
+		l.err = l.ReadBatch(l.NextBatch(), d.Top)
+	}
+	return 0
+}
+
+func (l *NpyDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+
+// Close unmaps the arrays.
+func (l *NpyDataLayer) Close() error {
+	var err error
+	for _, a := range l.arrays {
+		if closeErr := a.close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	l.arrays = nil
+	return err
+}
+
+// NpzDataLayer reads the arrays ArrayNames of the NumPy .npz archive FileName,
+// one per top and by default named after the tops, into memory and feeds
+// NumInBatch items per FeedForward like a SliceDataLayer. Items run along the
+// first axis of each array as for NpyDataLayer.
+type NpzDataLayer struct {
+	BaseLayer
+	FileName    string
+	ArrayNames  []string
+	NumInBatch  int
+	Shuffle     bool
+	LastBatch   LastBatch
+	Transformer *DataTransformer
+	items       SliceDataLayer
+}
+
+var _ = DataLayer(new(NpzDataLayer))
+var _ = BatchReader(new(NpzDataLayer))
+
+func (l *NpzDataLayer) Setup(d *LayerData) error {
+	err := l.checkBottomNames(0)
+	if err != nil {
+		return err
+	}
+	arrayNames := l.ArrayNames
+	if arrayNames == nil {
+		arrayNames = l.TopNames
+	}
+	err = l.checkTopNames(len(arrayNames))
+	if err != nil {
+		return err
+	}
+
+	data := make([][]float32, len(arrayNames))
+	dims := make([]BlobPoint, len(arrayNames))
+	for i, name := range arrayNames {
+		h, array, err := ReadNpz(l.FileName, name)
+		if err != nil {
+			return err
+		}
+		if len(h.Shape) == 0 {
+			return ErrNpyDataLayerInvalidArrays
+		}
+		dims[i], err = h.ItemDim()
+		if err != nil {
+			return err
+		}
+		data[i] = array
+	}
+
+	l.items = SliceDataLayer{
+		BaseLayer:   l.BaseLayer,
+		Data:        data,
+		Dims:        dims,
+		NumInBatch:  l.NumInBatch,
+		Shuffle:     l.Shuffle,
+		LastBatch:   l.LastBatch,
+		Transformer: l.Transformer,
+	}
+	err = l.items.Setup(d)
+	if err == ErrSliceDataLayerInvalidData {
+		return ErrNpyDataLayerInvalidArrays
+	}
+	return err
+}
+
+func (l *NpzDataLayer) FeedForward(d *LayerData) float32               { return l.items.FeedForward(d) }
+func (l *NpzDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+func (l *NpzDataLayer) NextBatch() *Batch                              { return l.items.NextBatch() }
+func (l *NpzDataLayer) ReadBatch(b *Batch, tops []*Blob) error         { return l.items.ReadBatch(b, tops) }
+func (l *NpzDataLayer) CurrentInputIndex() int                         { return l.items.CurrentInputIndex() }
+func (l *NpzDataLayer) NumInputs() int                                 { return l.items.NumInputs() }
+func (l *NpzDataLayer) Epoch() int                                     { return l.items.Epoch() }
+func (l *NpzDataLayer) EpochEnded() bool                               { return l.items.EpochEnded() }
+func (l *NpzDataLayer) NumInEpoch() int                                { return l.items.NumInEpoch() }
+func (l *NpzDataLayer) Reset()                                         { l.items.Reset() }
+func (l *NpzDataLayer) Err() error                                     { return l.items.Err() }
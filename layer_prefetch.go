@@ -0,0 +1,190 @@
+package godnn
+
+import (
+	"io"
+	"sync"
+)
+
+// PrefetchDataLayer reads the batches of another data layer in the
+// background, keeping up to QueueSize batches ready so that reading overlaps
+// with the rest of the network. When the data layer is a BatchReader,
+// NumWorkers goroutines read batches concurrently; batches are always
+// delivered in the order the data layer plans them. A batch that fails to
+// read sets Err. Reset drops the batches read ahead. Close stops the
+// background reads and closes the data layer.
+type PrefetchDataLayer struct {
+	DataLayer
+	NumWorkers int
+	QueueSize  int
+
+	buffers []*prefetchBuffer
+	random  *RandomSource
+	free    chan *prefetchBuffer
+	pending chan *prefetchBuffer
+	jobs    chan *prefetchBuffer
+	done    chan struct{}
+	wg      sync.WaitGroup
+	current Batch
+	err     error
+}
+
+var _ = DataLayer(new(PrefetchDataLayer))
+
+type prefetchBuffer struct {
+	tops   []*Blob
+	batch  *Batch
+	err    error
+	filled chan struct{}
+}
+
+func (l *PrefetchDataLayer) Setup(d *LayerData) error {
+	l.stop()
+	l.err = nil
+	err := l.DataLayer.Setup(d)
+	if err != nil {
+		return err
+	}
+	if l.NumWorkers <= 0 {
+		l.NumWorkers = 1
+	}
+	if l.QueueSize <= 0 {
+		l.QueueSize = 2
+	}
+
+	l.buffers = make([]*prefetchBuffer, l.QueueSize)
+	for i := range l.buffers {
+		buffer := &prefetchBuffer{tops: make([]*Blob, len(d.Top))}
+		for j, top := range d.Top {
+			buffer.tops[j] = NewBlob(top.Name+"_prefetch", &top.Dim)
+		}
+		l.buffers[i] = buffer
+	}
+	l.random = d.Random
+	l.start()
+	return nil
+}
+
+// start begins reading ahead from the current batch of the data layer.
+func (l *PrefetchDataLayer) start() {
+	l.free = make(chan *prefetchBuffer, l.QueueSize)
+	l.pending = make(chan *prefetchBuffer, l.QueueSize)
+	l.jobs = make(chan *prefetchBuffer)
+	l.done = make(chan struct{})
+	for _, buffer := range l.buffers {
+		l.free <- buffer
+	}
+	l.current = Batch{InputIndex: l.DataLayer.CurrentInputIndex(), Epoch: l.DataLayer.Epoch()}
+
+	reader, ok := l.DataLayer.(BatchReader)
+	l.wg.Add(1)
+	go l.plan(reader, ok)
+	if ok {
+		for i := 0; i < l.NumWorkers; i++ {
+			l.wg.Add(1)
+			go l.read(reader)
+		}
+	}
+}
+
+// plan hands out batches in order, reading them itself when the data layer
+// cannot read concurrently.
+func (l *PrefetchDataLayer) plan(reader BatchReader, concurrent bool) {
+	defer l.wg.Done()
+	defer close(l.jobs)
+	for {
+		var buffer *prefetchBuffer
+		select {
+		case buffer = <-l.free:
+		case <-l.done:
+			return
+		}
+
+		buffer.filled = make(chan struct{})
+		if concurrent {
+			buffer.batch = reader.NextBatch()
+			l.pending <- buffer
+			select {
+			case l.jobs <- buffer:
+			case <-l.done:
+				return
+			}
+			continue
+		}
+
+		l.DataLayer.FeedForward(&LayerData{Top: buffer.tops, Random: l.random})
+		buffer.batch = &Batch{
+			InputIndex: l.DataLayer.CurrentInputIndex(),
+			Epoch:      l.DataLayer.Epoch(),
+			EpochEnded: l.DataLayer.EpochEnded(),
+			NumInEpoch: l.DataLayer.NumInEpoch(),
+		}
+		buffer.err = l.DataLayer.Err()
+		close(buffer.filled)
+		l.pending <- buffer
+	}
+}
+
+func (l *PrefetchDataLayer) read(reader BatchReader) {
+	defer l.wg.Done()
+	for buffer := range l.jobs {
+		buffer.err = reader.ReadBatch(buffer.batch, buffer.tops)
+		close(buffer.filled)
+	}
+}
+
+func (l *PrefetchDataLayer) FeedForward(d *LayerData) float32 {
+	if l.err != nil {
+		return 0
+	}
+	buffer := <-l.pending
+	<-buffer.filled
+	if buffer.err != nil {
+		l.err = buffer.err
+		return 0
+	}
+	for i, top := range d.Top {
+		Copy32(buffer.tops[i].Data.CpuValues(), top.Data.MutableCpuValues(), top.Dim.Size(), 0)
+	}
+	l.current = *buffer.batch
+	l.free <- buffer
+	return 0
+}
+
+// Reset rewinds the data layer to the start of its epoch, dropping the
+// batches read ahead.
+func (l *PrefetchDataLayer) Reset() {
+	l.stop()
+	l.DataLayer.Reset()
+	l.start()
+}
+
+// stop ends the background reads.
+func (l *PrefetchDataLayer) stop() {
+	if l.done == nil {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
+	l.done = nil
+}
+
+// Close stops the background reads and closes the data layer if it is an
+// io.Closer, the layer can not be used again until the next Setup.
+func (l *PrefetchDataLayer) Close() error {
+	l.stop()
+	if closer, ok := l.DataLayer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (l *PrefetchDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+func (l *PrefetchDataLayer) CurrentInputIndex() int                         { return l.current.InputIndex }
+func (l *PrefetchDataLayer) Epoch() int                                     { return l.current.Epoch }
+func (l *PrefetchDataLayer) EpochEnded() bool                               { return l.current.EpochEnded }
+func (l *PrefetchDataLayer) NumInEpoch() int                                { return l.current.NumInEpoch }
+func (l *PrefetchDataLayer) Err() error                                     { return l.err }
+
+func NewPrefetchDataLayer(dataLayer DataLayer, numWorkers, queueSize int) *PrefetchDataLayer {
+	return &PrefetchDataLayer{DataLayer: dataLayer, NumWorkers: numWorkers, QueueSize: queueSize}
+}
@@ -0,0 +1,387 @@
+package godnn
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrSliceDataLayerInvalidData = errors.New("invalid slice data layer: needs one data slice of whole inputs per top")
+	ErrCSVDataLayerInvalidColumn = errors.New("invalid csv data layer: unknown column")
+	ErrCSVDataLayerInvalidValue  = errors.New("invalid csv data layer: non-numeric value in a selected column")
+	ErrCSVDataLayerNoHeader      = errors.New("invalid csv data layer: column names need a header row")
+)
+
+// SliceDataLayer reads NumInBatch inputs per FeedForward from memory. Data[i]
+// holds the inputs of top i back to back, each of Dims[i], whose batch is
+// ignored. With Shuffle the inputs are read in a new random order every
+// epoch. Transformer, if set, preprocesses the inputs of its tops.
+type SliceDataLayer struct {
+	BaseLayer
+	Data        [][]float32
+	Dims        []BlobPoint
+	NumInBatch  int
+	Shuffle     bool
+	LastBatch   LastBatch
+	Transformer *DataTransformer
+	itemDims    []BlobPoint
+	inputSampler
+}
+
+var _ = DataLayer(new(SliceDataLayer))
+var _ = BatchReader(new(SliceDataLayer))
+
+func NewSliceDataLayer(baseLayer BaseLayer, data [][]float32, dims []BlobPoint, numInBatch int) *SliceDataLayer {
+	return &SliceDataLayer{BaseLayer: baseLayer, Data: data, Dims: dims, NumInBatch: numInBatch}
+}
+
+func (l *SliceDataLayer) Setup(d *LayerData) error {
+	err := l.checkNames(0, len(l.Data))
+	if err != nil {
+		return err
+	}
+	if len(l.Data) == 0 || len(l.Dims) != len(l.Data) {
+		return ErrSliceDataLayerInvalidData
+	}
+
+	numInputs := 0
+	l.itemDims = make([]BlobPoint, len(l.Dims))
+	dims := make([]*BlobPoint, len(l.Dims))
+	for i, dim := range l.Dims {
+		dim.Batch = 1
+		l.itemDims[i] = dim
+		if dim.Size() == 0 || len(l.Data[i])%dim.Size() != 0 {
+			return ErrSliceDataLayerInvalidData
+		}
+		if i > 0 && len(l.Data[i])/dim.Size() != numInputs {
+			return ErrSliceDataLayerInvalidData
+		}
+		numInputs = len(l.Data[i]) / dim.Size()
+		dims[i] = &BlobPoint{l.NumInBatch, dim.Channel, dim.Height, dim.Width}
+	}
+
+	topDims, err := setupTransformer(l.Transformer, l.TopNames, dims)
+	if err != nil {
+		return err
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		for i, topName := range l.TopNames {
+			d.Top[i] = NewBlob(topName, topDims[i])
+		}
+	}
+
+	return l.setupSampler(numInputs, l.NumInBatch, l.Shuffle, l.LastBatch, l.Transformer, d.RandomSource())
+}
+
+func (l *SliceDataLayer) ReadBatch(b *Batch, tops []*Blob) error {
+	for topIndex, top := range tops {
+		topData := top.Data.MutableCpuValues()
+		size := l.itemDims[topIndex].Size()
+		transform := l.Transformer != nil && l.Transformer.Transforms(l.TopNames, topIndex)
+		for n, input := range b.Inputs {
+			inputData := Subslice32(l.Data[topIndex], input, size)
+			if !transform {
+				copy(Subslice32(topData, n, size), inputData)
+				continue
+			}
+			l.Transformer.Transform(&b.Transforms[n], inputData, Subslice32(topData, n, top.Dim.BatchSize()))
+		}
+	}
+	return nil
+}
+
+func (l *SliceDataLayer) FeedForward(d *LayerData) float32 {
+	if l.err == nil {
+		l.err = l.ReadBatch(l.NextBatch(), d.Top)
+	}
+	return 0
+}
+
+func (l *SliceDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+
+// CSVDataLayer streams the numeric columns of a CSV or TSV file, reading
+// NumInBatch rows per FeedForward from the file. Setup checks every row and
+// keeps only the offset where each starts, so rows can be shuffled and read
+// concurrently. Its first top holds FeatureColumns, by default every column
+// but the label columns, and its optional second top LabelColumns, by default
+// the first column. Columns count from 0, or, with a Header row, are named by
+// FeatureColumnNames and LabelColumnNames. Comma is the delimiter, by default
+// a tab for .tsv files and a comma otherwise. With Shuffle the rows are read
+// in a new random order every epoch. Transformer, if set, preprocesses the
+// rows of its tops. Close closes the file.
+type CSVDataLayer struct {
+	BaseLayer
+	FileName           string
+	Comma              rune
+	Header             bool
+	FeatureColumns     []int
+	LabelColumns       []int
+	FeatureColumnNames []string
+	LabelColumnNames   []string
+	NumInBatch         int
+	Shuffle            bool
+	LastBatch          LastBatch
+	Transformer        *DataTransformer
+	file               *os.File
+	size               int64
+	offsets            []int64
+	columns            [][]int
+	inputSampler
+}
+
+var _ = DataLayer(new(CSVDataLayer))
+var _ = BatchReader(new(CSVDataLayer))
+
+// columnIndices resolves column names against the header row.
+func columnIndices(header, names []string) ([]int, error) {
+	indices := make([]int, len(names))
+	for i, name := range names {
+		indices[i] = -1
+		for j, column := range header {
+			if strings.TrimSpace(column) == name {
+				indices[i] = j
+				break
+			}
+		}
+		if indices[i] < 0 {
+			return nil, ErrCSVDataLayerInvalidColumn
+		}
+	}
+	return indices, nil
+}
+
+// parseColumns parses the columns of a record into values.
+func parseColumns(record []string, columns []int, values []float32) error {
+	for i, j := range columns {
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[j]), 32)
+		if err != nil {
+			return ErrCSVDataLayerInvalidValue
+		}
+		values[i] = float32(v)
+	}
+	return nil
+}
+
+func (l *CSVDataLayer) newReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = l.Comma
+	if reader.Comma == 0 {
+		reader.Comma = ','
+		if strings.HasSuffix(l.FileName, ".tsv") {
+			reader.Comma = '\t'
+		}
+	}
+	return reader
+}
+
+// resolveColumns finds the columns of each top from the header and the
+// first row.
+func (l *CSVDataLayer) resolveColumns(header, first []string) error {
+	var err error
+	featureColumns, labelColumns := l.FeatureColumns, l.LabelColumns
+	if l.FeatureColumnNames != nil {
+		featureColumns, err = columnIndices(header, l.FeatureColumnNames)
+		if err != nil {
+			return err
+		}
+	}
+	if l.LabelColumnNames != nil {
+		labelColumns, err = columnIndices(header, l.LabelColumnNames)
+		if err != nil {
+			return err
+		}
+	}
+	if len(l.TopNames) == 1 {
+		labelColumns = nil
+	} else if labelColumns == nil {
+		labelColumns = []int{0}
+	}
+	if featureColumns == nil {
+		for j := range first {
+			if !containsInt(labelColumns, j) {
+				featureColumns = append(featureColumns, j)
+			}
+		}
+	}
+
+	l.columns = [][]int{featureColumns, labelColumns}[:len(l.TopNames)]
+	for _, columns := range l.columns {
+		for _, j := range columns {
+			if j < 0 || j >= len(first) {
+				return ErrCSVDataLayerInvalidColumn
+			}
+		}
+	}
+	return nil
+}
+
+// scan checks every row of the file and records where each starts.
+func (l *CSVDataLayer) scan() error {
+	r := l.newReader(l.file)
+	var header []string
+	if l.Header {
+		var err error
+		header, err = r.Read()
+		if err == io.EOF {
+			return ErrDataLayerNotEnoughInputs
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	l.offsets = nil
+	var values []float32
+	for {
+		offset := r.InputOffset()
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if l.offsets == nil {
+			err = l.resolveColumns(header, record)
+			if err != nil {
+				return err
+			}
+		}
+		for _, columns := range l.columns {
+			if len(values) < len(columns) {
+				values = make([]float32, len(columns))
+			}
+			err = parseColumns(record, columns, values)
+			if err != nil {
+				return err
+			}
+		}
+		l.offsets = append(l.offsets, offset)
+	}
+}
+
+func (l *CSVDataLayer) Setup(d *LayerData) (err error) {
+	err = l.checkBottomNames(0)
+	if err != nil {
+		return err
+	}
+	if len(l.TopNames) != 1 && len(l.TopNames) != 2 {
+		return ErrInvalidTopBlobNames
+	}
+	if !l.Header && (l.FeatureColumnNames != nil || l.LabelColumnNames != nil) {
+		return ErrCSVDataLayerNoHeader
+	}
+
+	l.Close()
+	l.file, err = os.Open(l.FileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			l.Close()
+		}
+	}()
+	err = l.scan()
+	if err != nil {
+		return err
+	}
+	if len(l.offsets) == 0 {
+		return ErrDataLayerNotEnoughInputs
+	}
+	l.size, err = l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	dims := make([]*BlobPoint, len(l.columns))
+	for i, columns := range l.columns {
+		dims[i] = &BlobPoint{l.NumInBatch, 1, 1, len(columns)}
+	}
+	topDims, err := setupTransformer(l.Transformer, l.TopNames, dims)
+	if err != nil {
+		return err
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		for i, topName := range l.TopNames {
+			d.Top[i] = NewBlob(topName, topDims[i])
+		}
+	}
+
+	return l.setupSampler(len(l.offsets), l.NumInBatch, l.Shuffle, l.LastBatch, l.Transformer, d.RandomSource())
+}
+
+// ReadBatch reads the rows of the batch from the file, reading on from one
+// row to the next where it can.
+func (l *CSVDataLayer) ReadBatch(b *Batch, tops []*Blob) error {
+	values := make([][]float32, len(tops))
+	for topIndex := range tops {
+		values[topIndex] = make([]float32, len(l.columns[topIndex]))
+	}
+
+	var r *csv.Reader
+	next := -1
+	for n, input := range b.Inputs {
+		if input != next {
+			r = l.newReader(io.NewSectionReader(l.file, l.offsets[input], l.size-l.offsets[input]))
+			r.FieldsPerRecord = -1
+			r.ReuseRecord = true
+		}
+		next = input + 1
+		record, err := r.Read()
+		if err != nil {
+			return err
+		}
+
+		for topIndex, top := range tops {
+			err = parseColumns(record, l.columns[topIndex], values[topIndex])
+			if err != nil {
+				return err
+			}
+			topData := top.Data.MutableCpuValues()
+			if l.Transformer != nil && l.Transformer.Transforms(l.TopNames, topIndex) {
+				l.Transformer.Transform(&b.Transforms[n], values[topIndex], Subslice32(topData, n, top.Dim.BatchSize()))
+				continue
+			}
+			copy(Subslice32(topData, n, len(values[topIndex])), values[topIndex])
+		}
+	}
+	return nil
+}
+
+func (l *CSVDataLayer) FeedForward(d *LayerData) float32 {
+	if l.err == nil {
+		l.err = l.ReadBatch(l.NextBatch(), d.Top)
+	}
+	return 0
+}
+
+func (l *CSVDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+
+// Close closes the file, the layer can not be used again until the next
+// Setup.
+func (l *CSVDataLayer) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package godnn
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrChannelDataLayerNoSource      = errors.New("invalid channel data layer: needs either samples or an iterator")
+	ErrChannelDataLayerInvalidSample = errors.New("invalid channel data layer sample: needs one value of the dims per top")
+)
+
+// Sample is one input of a ChannelDataLayer, one value per top.
+type Sample [][]float32
+
+// Iterator produces the samples of a ChannelDataLayer. Next blocks until a
+// sample is ready and returns false at the end of the stream.
+type Iterator interface {
+	Next() (Sample, bool)
+}
+
+// StreamDataLayer is a data layer over a stream of inputs that ends once,
+// rather than passing over a set of inputs in epochs. The stream ends with
+// the first FeedForward whose EpochEnded is true, which reads NumInEpoch
+// inputs, possibly none. A FeedForward whose inputs are late reads none, with
+// a NumInEpoch of 0, and the stream goes on. Err is the reason the stream
+// ended, nil when it ran out of inputs.
+type StreamDataLayer interface {
+	DataLayer
+	StreamEnded() bool
+}
+
+// ChannelDataLayer reads NumInBatch samples per FeedForward from the Samples
+// channel, or from Iterator, until the channel closes or the iterator ends.
+// Each value of a sample has the size of the Dims of its top, whose batch is
+// ignored. With a Timeout, a FeedForward waits at most that long for a full
+// batch; when it times out it reads no samples, keeping those that arrived
+// for the next FeedForward. A batch cut short by the end of the stream
+// repeats its samples to fill up. Transformer, if set, preprocesses the
+// samples of its tops. A goroutine feeds the samples of Iterator, blocking in Next until
+// the stream ends or the layer is closed.
+type ChannelDataLayer struct {
+	BaseLayer
+	Dims        []BlobPoint
+	NumInBatch  int
+	Samples     <-chan Sample
+	Iterator    Iterator
+	Timeout     time.Duration
+	Transformer *DataTransformer
+	samples     <-chan Sample
+	pending     []Sample
+	done        chan struct{}
+	itemDims    []BlobPoint
+	random      *RandomSource
+	numRead     int
+	ended       bool
+	epochEnded  bool
+	numInEpoch  int
+	err         error
+}
+
+var _ = StreamDataLayer(new(ChannelDataLayer))
+
+func NewChannelDataLayer(baseLayer BaseLayer, dims []BlobPoint, numInBatch int, samples <-chan Sample) *ChannelDataLayer {
+	return &ChannelDataLayer{BaseLayer: baseLayer, Dims: dims, NumInBatch: numInBatch, Samples: samples}
+}
+
+// iterate feeds the samples of an iterator into a channel until the iterator
+// ends or done is closed.
+func iterate(it Iterator, done <-chan struct{}) <-chan Sample {
+	samples := make(chan Sample)
+	go func() {
+		defer close(samples)
+		for {
+			s, ok := it.Next()
+			if !ok {
+				return
+			}
+			select {
+			case samples <- s:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return samples
+}
+
+func (l *ChannelDataLayer) Setup(d *LayerData) error {
+	err := l.checkNames(0, len(l.Dims))
+	if err != nil {
+		return err
+	}
+	if (l.Samples == nil) == (l.Iterator == nil) {
+		return ErrChannelDataLayerNoSource
+	}
+
+	l.itemDims = make([]BlobPoint, len(l.Dims))
+	dims := make([]*BlobPoint, len(l.Dims))
+	for i, dim := range l.Dims {
+		l.itemDims[i] = BlobPoint{1, dim.Channel, dim.Height, dim.Width}
+		dims[i] = &BlobPoint{l.NumInBatch, dim.Channel, dim.Height, dim.Width}
+	}
+	topDims, err := setupTransformer(l.Transformer, l.TopNames, dims)
+	if err != nil {
+		return err
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, len(l.TopNames))
+		for i, topName := range l.TopNames {
+			d.Top[i] = NewBlob(topName, topDims[i])
+		}
+	}
+
+	l.random = d.RandomSource()
+	if l.samples == nil {
+		l.samples = l.Samples
+		if l.Iterator != nil {
+			l.done = make(chan struct{})
+			l.samples = iterate(l.Iterator, l.done)
+		}
+	}
+	return nil
+}
+
+func (l *ChannelDataLayer) validSample(s Sample) bool {
+	if len(s) != len(l.itemDims) {
+		return false
+	}
+	for i, values := range s {
+		if len(values) != l.itemDims[i].Size() {
+			return false
+		}
+	}
+	return true
+}
+
+// readSample writes a sample into batch item n of the tops.
+func (l *ChannelDataLayer) readSample(s Sample, n int, tops []*Blob) {
+	var p ItemTransform
+	if l.Transformer != nil {
+		p = l.Transformer.Plan(l.random)
+	}
+	for i, top := range tops {
+		topData := top.Data.MutableCpuValues()
+		if l.Transformer != nil && l.Transformer.Transforms(l.TopNames, i) {
+			l.Transformer.Transform(&p, s[i], Subslice32(topData, n, top.Dim.BatchSize()))
+			continue
+		}
+		copy(Subslice32(topData, n, l.itemDims[i].Size()), s[i])
+	}
+}
+
+func (l *ChannelDataLayer) FeedForward(d *LayerData) float32 {
+	l.epochEnded = false
+	l.numInEpoch = 0
+	if l.ended {
+		return 0
+	}
+	l.numInEpoch = l.NumInBatch
+
+	var timeout <-chan time.Time
+	if l.Timeout > 0 {
+		timer := time.NewTimer(l.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+read:
+	for len(l.pending) < l.NumInBatch {
+		select {
+		case s, ok := <-l.samples:
+			if !ok {
+				l.ended = true
+				break read
+			}
+			if !l.validSample(s) {
+				l.ended = true
+				l.err = ErrChannelDataLayerInvalidSample
+				break read
+			}
+			l.pending = append(l.pending, s)
+		case <-timeout:
+			// late samples wait for the next batch
+			l.numInEpoch = 0
+			return 0
+		}
+	}
+
+	n := len(l.pending)
+	for m, s := range l.pending {
+		l.readSample(s, m, d.Top)
+	}
+	l.pending = l.pending[:0]
+
+	// fill up the short last batch of the stream with its samples
+	for m := n; n > 0 && m < l.NumInBatch; m++ {
+		for _, top := range d.Top {
+			topData := top.Data.MutableCpuValues()
+			size := top.Dim.BatchSize()
+			copy(Subslice32(topData, m, size), Subslice32(topData, m%n, size))
+		}
+	}
+
+	l.numRead += n
+	if l.ended {
+		l.epochEnded = true
+		l.numInEpoch = n
+	}
+	return 0
+}
+
+func (l *ChannelDataLayer) FeedBackward(d *LayerData, paramPropagate bool) {}
+
+// CurrentInputIndex and NumInputs are the number of samples read so far.
+func (l *ChannelDataLayer) CurrentInputIndex() int { return l.numRead }
+func (l *ChannelDataLayer) NumInputs() int         { return l.numRead }
+func (l *ChannelDataLayer) EpochEnded() bool       { return l.epochEnded }
+func (l *ChannelDataLayer) NumInEpoch() int        { return l.numInEpoch }
+func (l *ChannelDataLayer) StreamEnded() bool      { return l.ended }
+func (l *ChannelDataLayer) Err() error             { return l.err }
+
+// Reset does nothing, a stream can not rewind.
+func (l *ChannelDataLayer) Reset() {}
+
+// Close stops feeding the samples of Iterator once its pending Next returns,
+// the layer can not be used again until the next Setup.
+func (l *ChannelDataLayer) Close() error {
+	if l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
+	l.samples = nil
+	return nil
+}
+
+func (l *ChannelDataLayer) Epoch() int {
+	if l.ended {
+		return 1
+	}
+	return 0
+}
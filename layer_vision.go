@@ -4,7 +4,6 @@ import (
 	"errors"
 	"github.com/gonum/blas"
 	"math"
-	"math/rand"
 )
 
 type ConvolutionLayer struct {
@@ -18,6 +17,9 @@ type ConvolutionLayer struct {
 	StrideHeight int
 	StrideWidth  int
 	IncludeBias  bool
+	// WeightFiller defaults to a XavierFiller, BiasFiller to zero
+	WeightFiller Filler
+	BiasFiller   Filler
 
 	bottomDim      *BlobPoint
 	outputChannels int
@@ -69,17 +71,14 @@ func (l *ConvolutionLayer) Setup(d *LayerData) error {
 		l.weightParams = NewBlob(l.LayerName()+"_weight",
 			&BlobPoint{l.NumOutputs, l.outputChannels, l.KernelHeight, l.KernelWidth})
 		d.Params = []*Blob{l.weightParams}
+		kernelSize := l.KernelHeight * l.KernelWidth
+		fillParam(d, l.weightParams, l.WeightFiller, &XavierFiller{}, l.k, l.NumOutputs*kernelSize)
 		if l.IncludeBias {
 			l.biasParams = NewBlob(l.LayerName()+"_bias",
 				&BlobPoint{1, 1, 1, l.NumOutputs})
 			d.Params = append(d.Params, l.biasParams)
+			fillParam(d, l.biasParams, l.BiasFiller, &ConstantFiller{}, 1, l.NumOutputs)
 		}
-		weightData := l.weightParams.Data.MutableCpuValues()
-		weightScale := Sqrt32(3 / float32(l.bottomDim.BatchSize()))
-		for i, _ := range weightData {
-			weightData[i] = (rand.Float32() - 0.5) * 2.0 * weightScale
-		}
-		Set32(l.biasParams.Data.MutableCpuValues(), 0)
 	} else {
 		l.weightParams = d.Params[0]
 		if l.IncludeBias {
@@ -144,10 +143,6 @@ func (l *ConvolutionLayer) FeedForward(d *LayerData) float32 {
 func (l *ConvolutionLayer) FeedBackward(d *LayerData, paramPropagate bool) {
 	weight := l.weightParams.Data.CpuValues()
 	weightDiff := l.weightParams.Diff.MutableCpuValues()
-	Set32(weightDiff, 0)
-	biasDiff := l.biasParams.Diff.MutableCpuValues()
-	Set32(biasDiff, 0)
-	biasMultiplier := l.biasMultiplier.Data.CpuValues()
 
 	for i, top := range d.Top {
 		bottom := d.Bottom[i]
@@ -183,6 +178,8 @@ func (l *ConvolutionLayer) FeedBackward(d *LayerData, paramPropagate bool) {
 		}
 
 		if paramPropagate && l.IncludeBias {
+			biasDiff := l.biasParams.Diff.MutableCpuValues()
+			biasMultiplier := l.biasMultiplier.Data.CpuValues()
 			for n := 0; n < l.bottomDim.Batch; n++ {
 				topDiffSlice := Subslice32(topDiff, n, top.Dim.BatchSize())
 
@@ -415,3 +412,100 @@ func (l *PoolingLayer) feedBackwardAverage(topDiff, bottomDiff []float32) {
 		}
 	}
 }
+
+var (
+	ErrCropLayerInvalidOffsets = errors.New("invalid crop: offsets must keep the crop inside the bottom")
+)
+
+// CropLayer crops its first bottom to the size of its second bottom for every
+// axis from Axis on. Offsets holds one offset per cropped axis, a single
+// offset for all of them, or none to crop from the origin.
+type CropLayer struct {
+	BaseLayer
+	Axis    int
+	Offsets []int
+	offsets []int
+}
+
+var _ = Layer(new(CropLayer))
+
+func (l *CropLayer) Setup(d *LayerData) error {
+	err := l.checkNames(2, 1)
+	if err != nil {
+		return err
+	}
+	if l.Axis < 0 || l.Axis >= NumAxes {
+		return ErrInvalidAxis
+	}
+	numCropped := NumAxes - l.Axis
+	if len(l.Offsets) != 0 && len(l.Offsets) != 1 && len(l.Offsets) != numCropped {
+		return ErrCropLayerInvalidOffsets
+	}
+
+	bottomShape := d.Bottom[0].Dim.Shape()
+	topShape := d.Bottom[0].Dim.Shape()
+	referenceShape := d.Bottom[1].Dim.Shape()
+	l.offsets = make([]int, NumAxes)
+	for axis := l.Axis; axis < NumAxes; axis++ {
+		switch len(l.Offsets) {
+		case 1:
+			l.offsets[axis] = l.Offsets[0]
+		case numCropped:
+			l.offsets[axis] = l.Offsets[axis-l.Axis]
+		}
+		topShape[axis] = referenceShape[axis]
+		if l.offsets[axis] < 0 || l.offsets[axis]+topShape[axis] > bottomShape[axis] {
+			return ErrCropLayerInvalidOffsets
+		}
+	}
+
+	if d.Top == nil {
+		d.Top = make([]*Blob, 1)
+		d.Top[0] = NewBlob(l.TopNames[0], &BlobPoint{topShape[0], topShape[1], topShape[2], topShape[3]})
+	}
+
+	return nil
+}
+
+// crop visits each row along the width of the top with the offset of the
+// matching row in the bottom.
+func (l *CropLayer) crop(d *LayerData, fn func(topOffset, bottomOffset, width int)) {
+	topDim := d.Top[0].Dim
+	bottom := d.Bottom[0]
+	for n := 0; n < topDim.Batch; n++ {
+		for c := 0; c < topDim.Channel; c++ {
+			for h := 0; h < topDim.Height; h++ {
+				topOffset := ((n*topDim.Channel+c)*topDim.Height + h) * topDim.Width
+				bottomOffset := bottom.Offset(&BlobPoint{n + l.offsets[0], c + l.offsets[1],
+					h + l.offsets[2], l.offsets[3]})
+				fn(topOffset, bottomOffset, topDim.Width)
+			}
+		}
+	}
+}
+
+func (l *CropLayer) FeedForward(d *LayerData) float32 {
+	bottomData := d.Bottom[0].Data.CpuValues()
+	topData := d.Top[0].Data.MutableCpuValues()
+	l.crop(d, func(topOffset, bottomOffset, width int) {
+		Copy32(bottomData, topData[topOffset:], width, bottomOffset)
+	})
+	return 0
+}
+
+func (l *CropLayer) FeedBackward(d *LayerData, paramPropagate bool) {
+	topDiff := d.Top[0].Diff.CpuValues()
+	bottomDiff := d.Bottom[0].Diff.MutableCpuValues()
+	Set32(bottomDiff, 0)
+	l.crop(d, func(topOffset, bottomOffset, width int) {
+		Copy32(topDiff, bottomDiff[bottomOffset:], width, topOffset)
+	})
+}
+
+func NewCropLayer(baseLayer BaseLayer, axis int, offsets []int) *CropLayer {
+	return &CropLayer{
+		BaseLayer: baseLayer,
+		Axis:      axis,
+		Offsets:   offsets,
+	}
+}
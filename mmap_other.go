@@ -0,0 +1,18 @@
+//go:build !unix
+
+package godnn
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the file into memory where it cannot be mapped.
+func mapFile(f *os.File, size int) ([]byte, func([]byte) error, error) {
+	p := make([]byte, size)
+	_, err := io.ReadFull(io.NewSectionReader(f, 0, int64(size)), p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, func([]byte) error { return nil }, nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package godnn
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the file read-only into memory.
+func mapFile(f *os.File, size int) ([]byte, func([]byte) error, error) {
+	p, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, syscall.Munmap, nil
+}
@@ -2,11 +2,17 @@ package godnn
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"log"
 )
 
 var (
-	ErrUnreachableLayer = errors.New("invalid network definition, unreachable layers")
+	ErrUnreachableLayer     = errors.New("invalid network definition, unreachable layers")
+	ErrDuplicateTopBlobName = errors.New("invalid network definition, top blob name already in use")
+	ErrInvalidLossWeights   = errors.New("invalid network definition, need one loss weight per top")
+	ErrInvalidParamSpecs    = errors.New("invalid network definition, need one param spec per param")
+	ErrUnknownLayer         = errors.New("no layer with that name")
 )
 
 type Network struct {
@@ -14,20 +20,56 @@ type Network struct {
 	LayerDataByName map[string]*LayerData
 	BlobsByName     map[string]*Blob
 	UpdateParams    bool
+	Losses          []*NetworkLoss
+	// Random is the source each layer's random source is derived from.
+	Random *RandomSource
+
+	bottomNamesByLayer map[string][]string
+	frozenLayers       map[string]bool
+	// sharedLayerData is the layer data of the training network, if any.
+	sharedLayerData map[string]*LayerData
+}
+
+// NetworkLoss is a top blob that counts towards the network objective with
+// Weight. Value is the sum of its data from the last Forward.
+type NetworkLoss struct {
+	Name     string
+	Weight   float32
+	Value    float32
+	blobName string
+	blob     *Blob
 }
 
 func NewNetwork(layers []Layer) (*Network, error) {
-	return NewNetworkFromTraining(layers, nil)
+	return NewSeededNetwork(layers, DefaultSeed)
+}
+
+// NewSeededNetwork creates a network whose param fillers and stochastic
+// layers draw from random sources derived from seed.
+func NewSeededNetwork(layers []Layer, seed int64) (*Network, error) {
+	return newNetwork(layers, nil, NewRandomSource(seed))
 }
 
+// NewNetworkFromTraining creates a network that shares the blobs and params of
+// the layers with the same names in trainNet. Its layers draw from random
+// sources of their own, so they do not change the draws of trainNet.
 func NewNetworkFromTraining(layers []Layer, trainNet *Network) (*Network, error) {
+	if trainNet == nil {
+		return NewNetwork(layers)
+	}
+	return newNetwork(layers, trainNet, trainNet.Random.Derive("test"))
+}
+
+func newNetwork(layers []Layer, trainNet *Network, random *RandomSource) (*Network, error) {
 	n := new(Network)
 	n.Layers = make([]Layer, 0, len(layers))
+	n.Random = random
+	n.frozenLayers = make(map[string]bool)
+	n.LayerDataByName = make(map[string]*LayerData, len(layers))
 	if trainNet != nil {
-		n.LayerDataByName = trainNet.LayerDataByName
+		n.sharedLayerData = trainNet.LayerDataByName
 		n.BlobsByName = trainNet.BlobsByName
 	} else {
-		n.LayerDataByName = make(map[string]*LayerData, len(layers))
 		n.BlobsByName = make(map[string]*Blob)
 	}
 	err := n.initLayers(layers)
@@ -38,11 +80,15 @@ func NewNetworkFromTraining(layers []Layer, trainNet *Network) (*Network, error)
 }
 
 func (n *Network) initLayers(layers []Layer) error {
+	// route blobs with several consumers through split layers
+	layers = n.insertSplits(layers)
+
 	// create layer data and connect layers via blob names
 	added := make([]bool, len(layers))
 finalLayer:
 	for len(n.Layers) < len(layers) {
-		// find a layer that can be added (bottom blobs defined) and push to final layer
+		// find a layer that can be added (bottom blobs defined) and push to final layer,
+		// in-place layers rewrite their blob so they are applied in definition order
 		for i := 0; i < len(layers); i++ {
 			layer := layers[i]
 			if !added[i] && n.addableLayer(layer) {
@@ -57,16 +103,26 @@ finalLayer:
 		log.Println("Added Layers:", added)
 		return ErrUnreachableLayer
 	}
+
+	for _, loss := range n.Losses {
+		loss.blob = n.BlobsByName[loss.blobName]
+	}
 	return nil
 }
 
 func (n *Network) addLayer(layer Layer) error {
-	var layerData *LayerData
-	if data, ok := n.LayerDataByName[layer.LayerName()]; ok {
-		layerData = data
+	if lossWeights := layer.TopLossWeights(); lossWeights != nil && len(lossWeights) != len(layer.TopBlobNames()) {
+		return ErrInvalidLossWeights
+	}
+
+	layerData := new(LayerData)
+	layerData.Random = n.Random.Derive(layer.LayerName())
+	// a layer of the training network lends its blobs and params, not its random source
+	sharedData, shared := n.sharedLayerData[layer.LayerName()]
+	if shared {
+		layerData.Bottom, layerData.Top, layerData.Params = sharedData.Bottom, sharedData.Top, sharedData.Params
 	} else {
-		layerData = new(LayerData)
-		bottomNames := layer.BottomBlobNames()
+		bottomNames := n.bottomBlobNames(layer)
 		layerData.Bottom = make([]*Blob, len(bottomNames))
 		for i, bottomName := range bottomNames {
 			layerData.Bottom[i] = n.BlobsByName[bottomName]
@@ -77,7 +133,22 @@ func (n *Network) addLayer(layer Layer) error {
 	if err != nil {
 		return err
 	}
+	if specs := layer.LayerParamSpecs(); specs != nil {
+		if len(specs) != len(layerData.Params) {
+			return ErrInvalidParamSpecs
+		}
+		// params shared with a training network keep its multipliers
+		if !shared {
+			setParamSpecs(layerData.Params, specs)
+		}
+	}
 
+	// a top may only reuse a name when the layer computes in-place on that blob
+	for _, topBlob := range layerData.Top {
+		if blob, ok := n.BlobsByName[topBlob.Name]; ok && blob != topBlob {
+			return ErrDuplicateTopBlobName
+		}
+	}
 	for _, topBlob := range layerData.Top {
 		n.BlobsByName[topBlob.Name] = topBlob
 	}
@@ -87,8 +158,45 @@ func (n *Network) addLayer(layer Layer) error {
 	return nil
 }
 
+func setParamSpecs(params []*Blob, specs []ParamSpec) {
+	for i, param := range params {
+		param.LRMult, param.DecayMult = 1, 1
+		if specs != nil {
+			param.LRMult, param.DecayMult = specs[i].LRMult, specs[i].DecayMult
+		}
+	}
+}
+
+// SetLayerFrozen freezes or unfreezes the params of a layer. Backward passes
+// skip the param gradients of a frozen layer and the solver skips its params,
+// which get an LRMult of 0 until the layer is unfrozen.
+func (n *Network) SetLayerFrozen(name string, frozen bool) error {
+	for _, layer := range n.Layers {
+		if layer.LayerName() != name {
+			continue
+		}
+		params := n.LayerData(layer).Params
+		if frozen {
+			n.frozenLayers[name] = true
+			for _, param := range params {
+				param.LRMult = 0
+				param.ClearDiff()
+			}
+		} else if n.frozenLayers[name] {
+			delete(n.frozenLayers, name)
+			setParamSpecs(params, layer.LayerParamSpecs())
+		}
+		return nil
+	}
+	return ErrUnknownLayer
+}
+
+func (n *Network) LayerFrozen(name string) bool {
+	return n.frozenLayers[name]
+}
+
 func (n *Network) addableLayer(layer Layer) bool {
-	for _, bottomName := range layer.BottomBlobNames() {
+	for _, bottomName := range n.bottomBlobNames(layer) {
 		_, ok := n.BlobsByName[bottomName]
 		if !ok {
 			return false
@@ -97,47 +205,303 @@ func (n *Network) addableLayer(layer Layer) bool {
 	return true
 }
 
+// bottomBlobNames returns the blobs a layer is connected to, which differ from
+// the layer's own bottom names when a split layer has been inserted.
+func (n *Network) bottomBlobNames(layer Layer) []string {
+	if names, ok := n.bottomNamesByLayer[layer.LayerName()]; ok {
+		return names
+	}
+	return layer.BottomBlobNames()
+}
+
+type blobConsumer struct {
+	layer  int
+	bottom int
+}
+
+type blobVersion struct {
+	name      string
+	producer  int
+	topIndex  int
+	inPlace   *blobConsumer
+	consumers []blobConsumer
+}
+
+// insertSplits finds blobs consumed by more than one layer and inserts a
+// SplitLayer after their producer, so that each consumer gets its own diff
+// and the gradients are summed instead of overwriting each other. An in-place
+// layer creates a new version of its blob, so consumers are matched to the
+// version they see in definition order.
+func (n *Network) insertSplits(layers []Layer) []Layer {
+	versions := []*blobVersion{}
+	current := make(map[string]*blobVersion)
+	for i, layer := range layers {
+		bottomNames := layer.BottomBlobNames()
+		for j, bottomName := range bottomNames {
+			version, ok := current[bottomName]
+			if !ok {
+				version = &blobVersion{name: bottomName, producer: -1}
+				current[bottomName] = version
+				versions = append(versions, version)
+			}
+			version.consumers = append(version.consumers, blobConsumer{i, j})
+		}
+		for k, topName := range layer.TopBlobNames() {
+			version := &blobVersion{name: topName, producer: i, topIndex: k}
+			for j, bottomName := range bottomNames {
+				if bottomName == topName {
+					version.inPlace = &blobConsumer{i, j}
+					break
+				}
+			}
+			current[topName] = version
+			versions = append(versions, version)
+		}
+	}
+
+	resolved := make([][]string, len(layers))
+	for i, layer := range layers {
+		resolved[i] = make([]string, len(layer.BottomBlobNames()))
+	}
+	splitsByProducer := make(map[int][]Layer)
+	n.Losses = nil
+	for _, version := range versions {
+		blobName := version.name
+		if version.inPlace != nil {
+			blobName = resolved[version.inPlace.layer][version.inPlace.bottom]
+		}
+
+		// a weighted loss top counts as one more consumer of its blob
+		var loss *NetworkLoss
+		numConsumers := len(version.consumers)
+		if version.producer >= 0 {
+			if weight, ok := topLossWeight(layers[version.producer], version.topIndex); ok {
+				loss = &NetworkLoss{Name: version.name, Weight: weight, blobName: blobName}
+				n.Losses = append(n.Losses, loss)
+				if weight != 0 && numConsumers > 0 {
+					numConsumers++
+				}
+			}
+		}
+
+		if numConsumers < 2 {
+			for _, consumer := range version.consumers {
+				resolved[consumer.layer][consumer.bottom] = blobName
+				if version.producer < 0 {
+					continue
+				}
+				if split, ok := layers[version.producer].(*SplitLayer); ok {
+					n.splitConsumedInPlace(split, version.topIndex, layers[consumer.layer], consumer.bottom)
+				}
+			}
+			continue
+		}
+
+		producerName := ""
+		if version.producer >= 0 {
+			producerName = layers[version.producer].LayerName()
+		}
+		split := NewSplitLayer(BaseLayer{
+			Name:        fmt.Sprintf("%s_%s_%d_split", blobName, producerName, version.topIndex),
+			BottomNames: []string{blobName},
+			TopNames:    make([]string, numConsumers),
+		})
+		for c := range split.TopNames {
+			split.TopNames[c] = fmt.Sprintf("%s_%s_%d_split_%d", blobName, producerName, version.topIndex, c)
+		}
+		for c, consumer := range version.consumers {
+			resolved[consumer.layer][consumer.bottom] = split.TopNames[c]
+			n.splitConsumedInPlace(split, c, layers[consumer.layer], consumer.bottom)
+		}
+		if numConsumers > len(version.consumers) {
+			loss.blobName = split.TopNames[len(version.consumers)]
+		}
+		splitsByProducer[version.producer] = append(splitsByProducer[version.producer], split)
+	}
+
+	n.bottomNamesByLayer = make(map[string][]string, len(layers))
+	for i, layer := range layers {
+		n.bottomNamesByLayer[layer.LayerName()] = resolved[i]
+	}
+
+	splitLayers := append([]Layer{}, splitsByProducer[-1]...)
+	for i, layer := range layers {
+		splitLayers = append(splitLayers, layer)
+		splitLayers = append(splitLayers, splitsByProducer[i]...)
+	}
+	return splitLayers
+}
+
+// topLossWeight returns the loss weight of a top and whether it is a loss: it
+// has a non-zero weight or it is the first top of a LossLayer.
+func topLossWeight(layer Layer, topIndex int) (float32, bool) {
+	isLoss := false
+	if lossLayer, ok := layer.(LossLayer); ok && lossLayer.IsLoss() && topIndex == 0 {
+		isLoss = true
+	}
+	if lossWeights := layer.TopLossWeights(); lossWeights != nil {
+		if topIndex >= len(lossWeights) {
+			return 0, isLoss
+		}
+		return lossWeights[topIndex], isLoss || lossWeights[topIndex] != 0
+	}
+	if isLoss {
+		return 1, true
+	}
+	return 0, false
+}
+
+// splitConsumedInPlace gives a split top its own data when its consumer
+// computes in-place, since the data is otherwise shared with the other tops.
+func (n *Network) splitConsumedInPlace(split *SplitLayer, topIndex int, consumer Layer, bottomIndex int) {
+	bottomName := consumer.BottomBlobNames()[bottomIndex]
+	for _, topName := range consumer.TopBlobNames() {
+		if topName == bottomName {
+			if len(split.copyData) != len(split.TopNames) {
+				split.copyData = make([]bool, len(split.TopNames))
+			}
+			split.copyData[topIndex] = true
+			return
+		}
+	}
+}
+
 func (n *Network) ForwardBackward() float32 {
 	loss := n.Forward()
 	n.Backward(loss)
 	return loss
 }
 
+// Forward runs every layer and returns the weighted sum of the losses, the
+// value of each loss is kept in Losses.
 func (n *Network) Forward() float32 {
-	loss := float32(0)
 	for i := 0; i < len(n.Layers); i++ {
 		layer := n.Layers[i]
 		layerData := n.LayerData(layer)
-		loss += layer.FeedForward(layerData)
+		layer.FeedForward(layerData)
+	}
+
+	loss := float32(0)
+	for _, l := range n.Losses {
+		l.Value = 0
+		for _, v := range l.blob.Data.CpuValues() {
+			l.Value += v
+		}
+		loss += l.Weight * l.Value
 	}
 	return loss
 }
 
+// Backward seeds the diff of each loss top with its loss weight and runs
+// every layer backwards.
 func (n *Network) Backward(loss float32) {
+	for _, l := range n.Losses {
+		Set32(l.blob.Diff.MutableCpuValues(), l.Weight)
+	}
 	for i := len(n.Layers) - 1; i >= 0; i-- {
 		layer := n.Layers[i]
 		layerData := n.LayerData(layer)
-		layer.FeedBackward(layerData, n.UpdateParams)
+		layer.FeedBackward(layerData, n.UpdateParams && !n.frozenLayers[layer.LayerName()])
+	}
+}
+
+// LossesByName returns the value of each loss from the last Forward.
+func (n *Network) LossesByName() map[string]float32 {
+	losses := make(map[string]float32, len(n.Losses))
+	for _, l := range n.Losses {
+		losses[l.Name] = l.Value
+	}
+	return losses
+}
+
+// OutputsByName returns the value of each loss and of every single value top
+// that no layer consumes, such as an accuracy, from the last Forward.
+func (n *Network) OutputsByName() map[string]float32 {
+	outputs := n.LossesByName()
+	consumed := make(map[*Blob]bool)
+	for _, l := range n.Losses {
+		consumed[l.blob] = true
+	}
+	for _, layer := range n.Layers {
+		for _, bottom := range n.LayerData(layer).Bottom {
+			consumed[bottom] = true
+		}
+	}
+	for _, layer := range n.Layers {
+		for _, top := range n.LayerData(layer).Top {
+			if !consumed[top] && top.Dim.Size() == 1 {
+				outputs[top.Name] = top.Data.CpuValues()[0]
+			}
+		}
+	}
+	return outputs
+}
+
+// ClearParamDiffs zeroes the diffs of all params, before accumulating
+// gradients over one or more backward passes.
+func (n *Network) ClearParamDiffs() {
+	for _, param := range n.Params() {
+		param.ClearDiff()
 	}
 }
 
+// ScaleParamDiffs multiplies the diffs of all params by alpha.
+func (n *Network) ScaleParamDiffs(alpha float32) {
+	for _, param := range n.Params() {
+		param.ScaleDiff(alpha)
+	}
+}
+
+// Update adds the param diffs, which the solver has turned into updates, to
+// the params and then clears the diffs for the next iteration.
 func (n *Network) Update() {
-	for _, layerData := range n.LayerDataByName {
-		for _, param := range layerData.Params {
-			paramDiff := param.Diff.CpuValues()
-			paramData := param.Data.MutableCpuValues()
+	for _, param := range n.Params() {
+		paramDiff := param.Diff.CpuValues()
+		paramData := param.Data.MutableCpuValues()
+		if param.DiffRows == nil {
 			Axpy32(len(paramDiff), +1, paramDiff, paramData)
+			continue
+		}
+		width := param.Dim.Width
+		for _, row := range param.DiffRows {
+			Axpy32(width, +1, Subslice32(paramDiff, row, width), Subslice32(paramData, row, width))
+		}
+	}
+	n.ClearParamDiffs()
+}
+
+// Close closes the layers that are an io.Closer, such as data layers holding
+// files or background reads, and returns the first error.
+func (n *Network) Close() error {
+	var err error
+	for _, layer := range n.Layers {
+		if closer, ok := layer.(io.Closer); ok {
+			if closeErr := closer.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
 		}
 	}
+	return err
 }
 
 func (n *Network) LayerData(layer Layer) *LayerData {
 	return n.LayerDataByName[layer.LayerName()]
 }
 
+// Params returns the params of the network's layers in layer order, so that
+// reductions over them, like the gradient norm, are reproducible.
 func (n *Network) Params() []*Blob {
 	params := []*Blob{}
-	for _, layerData := range n.LayerDataByName {
+	seen := make(map[*LayerData]bool, len(n.Layers))
+	for _, layer := range n.Layers {
+		if _, ok := layer.(*SplitLayer); ok {
+			continue
+		}
+		layerData := n.LayerData(layer)
+		if layerData == nil || seen[layerData] {
+			continue
+		}
+		seen[layerData] = true
 		params = append(params, layerData.Params...)
 	}
 	return params
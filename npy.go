@@ -0,0 +1,273 @@
+package godnn
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNpyInvalidHeader = errors.New("invalid npy header")
+	ErrNpyUnsupported   = errors.New("unsupported npy array: needs a C ordered numeric dtype")
+	ErrNpyInvalidData   = errors.New("npy data does not match its header")
+	ErrTooManyAxes      = errors.New("array has more than 4 axes")
+	ErrNpzUnknownArray  = errors.New("unknown npz array")
+)
+
+const npyMagic = "\x93NUMPY"
+
+// NpyHeader describes the array of a NumPy .npy file. Dtype is a NumPy type
+// string such as "<f4".
+type NpyHeader struct {
+	Dtype        string
+	FortranOrder bool
+	Shape        []int
+}
+
+// ReadNpyHeader reads the header of a .npy file and returns it with the
+// offset of the array data from the start of the file. Only C ordered arrays
+// of bool, integer and float dtypes are supported.
+func ReadNpyHeader(r io.Reader) (*NpyHeader, int, error) {
+	prefix := make([]byte, 8)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, 0, err
+	}
+	if string(prefix[:6]) != npyMagic {
+		return nil, 0, ErrNpyInvalidHeader
+	}
+	offset := 10
+	var headerLen int
+	switch prefix[6] {
+	case 1:
+		p := make([]byte, 2)
+		if _, err := io.ReadFull(r, p); err != nil {
+			return nil, 0, err
+		}
+		headerLen = int(binary.LittleEndian.Uint16(p))
+	case 2, 3:
+		p := make([]byte, 4)
+		if _, err := io.ReadFull(r, p); err != nil {
+			return nil, 0, err
+		}
+		headerLen = int(binary.LittleEndian.Uint32(p))
+		offset = 12
+	default:
+		return nil, 0, ErrNpyInvalidHeader
+	}
+	p := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, 0, err
+	}
+
+	h, err := parseNpyHeader(string(p))
+	if err != nil {
+		return nil, 0, err
+	}
+	if h.FortranOrder || npyItemSize(h.Dtype) == 0 {
+		return nil, 0, ErrNpyUnsupported
+	}
+	return h, offset + headerLen, nil
+}
+
+// parseNpyHeader parses the Python dict literal of a .npy header, like
+// {'descr': '<f4', 'fortran_order': False, 'shape': (10, 3), }.
+func parseNpyHeader(s string) (*NpyHeader, error) {
+	h := new(NpyHeader)
+	descr, ok := npyHeaderValue(s, "descr")
+	if !ok {
+		return nil, ErrNpyInvalidHeader
+	}
+	h.Dtype = strings.Trim(descr, "'\"")
+
+	fortranOrder, ok := npyHeaderValue(s, "fortran_order")
+	if !ok {
+		return nil, ErrNpyInvalidHeader
+	}
+	h.FortranOrder = fortranOrder == "True"
+
+	shape, ok := npyHeaderValue(s, "shape")
+	if !ok || !strings.HasPrefix(shape, "(") {
+		return nil, ErrNpyInvalidHeader
+	}
+	for _, axis := range strings.Split(strings.Trim(shape, "()"), ",") {
+		axis = strings.TrimSpace(axis)
+		if axis == "" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(axis, "L"))
+		if err != nil {
+			return nil, ErrNpyInvalidHeader
+		}
+		h.Shape = append(h.Shape, n)
+	}
+	return h, nil
+}
+
+// npyHeaderValue returns the literal of key in a .npy header dict.
+func npyHeaderValue(s, key string) (string, bool) {
+	i := strings.Index(s, "'"+key+"'")
+	if i < 0 {
+		return "", false
+	}
+	s = strings.TrimSpace(s[i+len(key)+2:])
+	if !strings.HasPrefix(s, ":") {
+		return "", false
+	}
+	s = strings.TrimSpace(s[1:])
+	end := strings.IndexAny(s, ",}")
+	if strings.HasPrefix(s, "(") {
+		end = strings.Index(s, ")") + 1
+	}
+	if end <= 0 {
+		return "", false
+	}
+	return strings.TrimSpace(s[:end]), true
+}
+
+func npyItemSize(dtype string) int {
+	if len(dtype) < 3 || strings.IndexByte("<>|=", dtype[0]) < 0 {
+		return 0
+	}
+	switch dtype[1:] {
+	case "b1", "u1", "i1":
+		return 1
+	case "u2", "i2":
+		return 2
+	case "u4", "i4", "f4":
+		return 4
+	case "u8", "i8", "f8":
+		return 8
+	}
+	return 0
+}
+
+// ItemSize is the number of bytes of an array element.
+func (h *NpyHeader) ItemSize() int {
+	return npyItemSize(h.Dtype)
+}
+
+// NumElements is the number of elements of the array.
+func (h *NpyHeader) NumElements() int {
+	n := 1
+	for _, axis := range h.Shape {
+		n *= axis
+	}
+	return n
+}
+
+// IsFloat reports whether the array holds floats.
+func (h *NpyHeader) IsFloat() bool {
+	return h.Dtype[1] == 'f'
+}
+
+// ItemDim is the dimension of one item along the first axis of the array.
+func (h *NpyHeader) ItemDim() (BlobPoint, error) {
+	return ItemDim(h.Shape)
+}
+
+// ItemDim is the dimension of one item along the first axis of an array of
+// shape, with the remaining axes aligned to (channel, height, width) from the
+// right, so that (N, D) items are (1, 1, 1, D).
+func ItemDim(shape []int) (BlobPoint, error) {
+	dim := BlobPoint{1, 1, 1, 1}
+	if len(shape) > 4 {
+		return dim, ErrTooManyAxes
+	}
+	axes := []*int{&dim.Channel, &dim.Height, &dim.Width}
+	for i := 1; i < len(shape); i++ {
+		*axes[3-len(shape)+i] = shape[i]
+	}
+	return dim, nil
+}
+
+// DecodeNpy converts array data of the header's dtype into data.
+func DecodeNpy(h *NpyHeader, p []byte, data []float32) error {
+	itemSize := h.ItemSize()
+	if len(p) != itemSize*len(data) {
+		return ErrNpyInvalidData
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if h.Dtype[0] == '>' {
+		order = binary.BigEndian
+	}
+	kind := h.Dtype[1:]
+	for i := range data {
+		q := p[i*itemSize:]
+		switch kind {
+		case "b1", "u1":
+			data[i] = float32(q[0])
+		case "i1":
+			data[i] = float32(int8(q[0]))
+		case "u2":
+			data[i] = float32(order.Uint16(q))
+		case "i2":
+			data[i] = float32(int16(order.Uint16(q)))
+		case "u4":
+			data[i] = float32(order.Uint32(q))
+		case "i4":
+			data[i] = float32(int32(order.Uint32(q)))
+		case "f4":
+			data[i] = math.Float32frombits(order.Uint32(q))
+		case "u8":
+			data[i] = float32(order.Uint64(q))
+		case "i8":
+			data[i] = float32(int64(order.Uint64(q)))
+		case "f8":
+			data[i] = float32(math.Float64frombits(order.Uint64(q)))
+		}
+	}
+	return nil
+}
+
+// ReadNpy reads a whole .npy array.
+func ReadNpy(r io.Reader) (*NpyHeader, []float32, error) {
+	h, _, err := ReadNpyHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := make([]byte, h.ItemSize()*h.NumElements())
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, nil, ErrNpyInvalidData
+	}
+	data := make([]float32, h.NumElements())
+	return h, data, DecodeNpy(h, p, data)
+}
+
+// NpzArrayNames returns the names of the arrays of a .npz file.
+func NpzArrayNames(fileName string) ([]string, error) {
+	z, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	var names []string
+	for _, f := range z.File {
+		names = append(names, strings.TrimSuffix(f.Name, ".npy"))
+	}
+	return names, nil
+}
+
+// ReadNpz reads the array name of a .npz file.
+func ReadNpz(fileName, name string) (*NpyHeader, []float32, error) {
+	z, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if strings.TrimSuffix(f.Name, ".npy") != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer r.Close()
+		return ReadNpy(r)
+	}
+	return nil, nil, ErrNpzUnknownArray
+}
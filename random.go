@@ -0,0 +1,33 @@
+package godnn
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// DefaultSeed seeds networks created without an explicit seed.
+const DefaultSeed = int64(1)
+
+// RandomSource is a seeded random number generator. A network derives one
+// for each layer from its own seed and the layer name, so a layer draws the
+// same numbers whatever other layers do, and runs with the same seed match.
+type RandomSource struct {
+	*rand.Rand
+	seed int64
+}
+
+func NewRandomSource(seed int64) *RandomSource {
+	return &RandomSource{Rand: rand.New(rand.NewSource(seed)), seed: seed}
+}
+
+func (r *RandomSource) Seed() int64 {
+	return r.seed
+}
+
+// Derive returns an independent source for the named consumer, such as a
+// layer or a data loading worker.
+func (r *RandomSource) Derive(name string) *RandomSource {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return NewRandomSource(r.seed ^ int64(h.Sum64()))
+}
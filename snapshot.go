@@ -0,0 +1,169 @@
+package godnn
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+var (
+	ErrSnapshotLayerMismatch = errors.New("invalid snapshot: layers or param shapes do not match the network")
+	ErrSnapshotInvalidData   = errors.New("invalid snapshot: param data does not match its shape")
+)
+
+// A snapshot holds the params of every layer, by layer name.
+type snapshotParam struct {
+	Name string
+	Dim  BlobPoint
+	Data []float32
+}
+
+type snapshotLayer struct {
+	Name   string
+	Params []snapshotParam
+}
+
+// Save writes the params of every layer to w.
+func (n *Network) Save(w io.Writer) error {
+	layers := make([]snapshotLayer, 0, len(n.Layers))
+	for _, layer := range n.Layers {
+		params := n.LayerData(layer).Params
+		if len(params) == 0 {
+			continue
+		}
+		s := snapshotLayer{Name: layer.LayerName(), Params: make([]snapshotParam, len(params))}
+		for i, param := range params {
+			s.Params[i] = snapshotParam{Name: param.Name, Dim: param.Dim, Data: param.Data.CpuValues()}
+		}
+		layers = append(layers, s)
+	}
+	return gob.NewEncoder(w).Encode(layers)
+}
+
+// readSnapshot decodes the layers of a snapshot, whose params must each hold
+// the data of their shape.
+func readSnapshot(r io.Reader) (map[string]snapshotLayer, error) {
+	var layers []snapshotLayer
+	err := gob.NewDecoder(r).Decode(&layers)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]snapshotLayer, len(layers))
+	for _, s := range layers {
+		for _, param := range s.Params {
+			if len(param.Data) != param.Dim.Size() {
+				return nil, ErrSnapshotInvalidData
+			}
+		}
+		byName[s.Name] = s
+	}
+	return byName, nil
+}
+
+// matches reports whether the snapshot has params of the same shapes.
+func (s *snapshotLayer) matches(params []*Blob) bool {
+	if len(s.Params) != len(params) {
+		return false
+	}
+	for i, param := range params {
+		if s.Params[i].Dim != param.Dim {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *snapshotLayer) copyTo(params []*Blob) {
+	for i, param := range params {
+		Copy32(s.Params[i].Data, param.Data.MutableCpuValues(), param.Dim.Size(), 0)
+	}
+}
+
+// Load reads params written by Save into the layers with the same names. Every
+// layer with params must be in the snapshot with params of the same shapes.
+func (n *Network) Load(r io.Reader) error {
+	byName, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	for _, layer := range n.Layers {
+		params := n.LayerData(layer).Params
+		if len(params) == 0 {
+			continue
+		}
+		s, ok := byName[layer.LayerName()]
+		if !ok || !s.matches(params) {
+			return ErrSnapshotLayerMismatch
+		}
+	}
+	for _, layer := range n.Layers {
+		params := n.LayerData(layer).Params
+		if len(params) > 0 {
+			s := byName[layer.LayerName()]
+			s.copyTo(params)
+		}
+	}
+	return nil
+}
+
+// CopyTrainedLayers reads params written by Save into the layers with the
+// same names and param shapes, and returns the names of the layers copied.
+// Other layers keep their params, so a network can be fine-tuned from a
+// pretrained one with, for example, a different classifier head.
+func (n *Network) CopyTrainedLayers(r io.Reader) ([]string, error) {
+	byName, err := readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	copied := []string{}
+	for _, layer := range n.Layers {
+		params := n.LayerData(layer).Params
+		if len(params) == 0 {
+			continue
+		}
+		s, ok := byName[layer.LayerName()]
+		if !ok {
+			log.Printf("Not copying layer %s: not in the snapshot\n", layer.LayerName())
+			continue
+		}
+		if !s.matches(params) {
+			log.Printf("Not copying layer %s: param shapes differ\n", layer.LayerName())
+			continue
+		}
+		s.copyTo(params)
+		copied = append(copied, layer.LayerName())
+	}
+	return copied, nil
+}
+
+func (n *Network) SaveFile(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = n.Save(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (n *Network) LoadFile(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return n.Load(f)
+}
+
+func (n *Network) CopyTrainedLayersFromFile(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return n.CopyTrainedLayers(f)
+}
@@ -4,10 +4,24 @@ type Solver interface {
 	ComputeUpdates()
 }
 
+// Regularization selects the weight decay penalty.
+type Regularization int
+
+const (
+	RegularizationL2 Regularization = iota
+	RegularizationL1
+)
+
+// SgdSolver is stochastic gradient descent with momentum. Each param's
+// learning rate and weight decay are scaled by its LRMult and DecayMult. When
+// ClipGradients is positive, the diffs are first scaled down so that their
+// global L2 norm is at most ClipGradients.
 type SgdSolver struct {
 	Momentum         float32
 	BaseLearningRate float32
 	WeightDecay      float32
+	Regularization   Regularization
+	ClipGradients    float32
 	Gamma            float32
 	StepSize         int
 	net              *Network
@@ -19,7 +33,16 @@ type SgdSolver struct {
 
 func (s *SgdSolver) ComputeUpdates() {
 	s.iterations++
+	rate := s.calculateRate()
+	ClipGradients(s.netParams, s.ClipGradients)
 	for i, param := range s.netParams {
+		if param.LRMult == 0 {
+			// frozen params get no update
+			param.ClearDiff()
+			continue
+		}
+		paramRate := rate * param.LRMult
+		paramDecay := s.WeightDecay * param.DecayMult
 		paramData := param.Data.CpuValues()
 		paramDiff := param.Diff.MutableCpuValues()
 
@@ -27,16 +50,74 @@ func (s *SgdSolver) ComputeUpdates() {
 		paramTemp := lastParam.Data.MutableCpuValues()
 		lastParamDiff := lastParam.Diff.MutableCpuValues()
 
-		// Apply Weight Decay
-		Axpy32(len(paramData), s.WeightDecay, paramData, paramDiff)
+		if param.DiffRows == nil {
+			Regularize(paramData, paramDiff, paramDecay, s.Regularization)
+			s.computeUpdate(paramRate, paramDiff, paramTemp, lastParamDiff)
+			continue
+		}
+
+		// Sparse diffs only update (and decay) the rows that were written
+		width := param.Dim.Width
+		for _, row := range param.DiffRows {
+			rowDiff := Subslice32(paramDiff, row, width)
+			Regularize(Subslice32(paramData, row, width), rowDiff, paramDecay, s.Regularization)
+			s.computeUpdate(paramRate, rowDiff, Subslice32(paramTemp, row, width), Subslice32(lastParamDiff, row, width))
+		}
+	}
+}
+
+func (s *SgdSolver) computeUpdate(rate float32, paramDiff, paramTemp, lastParamDiff []float32) {
+	// Compute Param Updates
+	Set32(paramTemp, 0)
+	Axpy32(len(paramTemp), s.Momentum, lastParamDiff, paramTemp)
+	Axpy32(len(paramTemp), -rate, paramDiff, paramTemp)
+	Copy32(paramTemp, lastParamDiff, len(paramTemp), 0)
+	Copy32(paramTemp, paramDiff, len(paramTemp), 0)
+}
+
+// Regularize adds the gradient of the weight decay penalty of data to diff.
+func Regularize(data, diff []float32, decay float32, regularization Regularization) {
+	if decay == 0 {
+		return
+	}
+	switch regularization {
+	case RegularizationL1:
+		for i, x := range data {
+			if x > 0 {
+				diff[i] += decay
+			} else if x < 0 {
+				diff[i] -= decay
+			}
+		}
+	default:
+		Axpy32(len(data), decay, data, diff)
+	}
+}
 
-		// Compute Param Updates
-		rate := s.calculateRate()
-		Set32(paramTemp, 0)
-		Axpy32(len(paramTemp), s.Momentum, lastParamDiff, paramTemp)
-		Axpy32(len(paramTemp), -rate, paramDiff, paramTemp)
-		Copy32(paramTemp, lastParamDiff, len(paramTemp), 0)
-		Copy32(paramTemp, paramDiff, len(paramTemp), 0)
+// ClipGradients scales the diffs of params down so that their global L2
+// norm is at most threshold. A threshold of 0 disables clipping.
+func ClipGradients(params []*Blob, threshold float32) {
+	if threshold <= 0 {
+		return
+	}
+	sumSq := float32(0)
+	for _, param := range params {
+		diff := param.Diff.CpuValues()
+		if param.DiffRows == nil {
+			sumSq += Dot32(len(diff), diff, 1, diff, 1)
+			continue
+		}
+		for _, row := range param.DiffRows {
+			rowDiff := Subslice32(diff, row, param.Dim.Width)
+			sumSq += Dot32(len(rowDiff), rowDiff, 1, rowDiff, 1)
+		}
+	}
+	norm := Sqrt32(sumSq)
+	if norm <= threshold {
+		return
+	}
+	for _, param := range params {
+		param.ScaleDiff(threshold / norm)
 	}
 }
 
@@ -0,0 +1,234 @@
+package godnn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// Storage types of the values of a bucket, stored under the "type" key of its
+// "_dim" bucket. Buckets without a type hold bytes.
+const (
+	StorageByte    = "byte"
+	StorageUint8   = "uint8"
+	StorageInt32   = "int32"
+	StorageFloat32 = "float32"
+	StoragePng     = "png"
+	StorageJpeg    = "jpeg"
+)
+
+var (
+	ErrStorageUnknownType  = errors.New("unknown storage type")
+	ErrStorageInvalidValue = errors.New("stored value does not match the bucket dimensions")
+)
+
+func checkStorageType(storageType string) error {
+	switch storageType {
+	case StorageByte, StorageUint8, StorageInt32, StorageFloat32, StoragePng, StorageJpeg:
+		return nil
+	}
+	return ErrStorageUnknownType
+}
+
+// DecodeValue decodes a stored value of one input of dim, which has a batch
+// of 1, into data. Images decode to (channel, height, width) order with
+// values in [0, 255], gray for 1 channel, RGB for 3 and RGBA for 4.
+func DecodeValue(storageType string, value []byte, dim *BlobPoint, data []float32) error {
+	switch storageType {
+	case StorageByte, StorageUint8:
+		if len(value) != len(data) {
+			return ErrStorageInvalidValue
+		}
+		for i := range data {
+			data[i] = float32(value[i])
+		}
+	case StorageInt32:
+		if len(value) != 4*len(data) {
+			return ErrStorageInvalidValue
+		}
+		for i := range data {
+			data[i] = float32(int32(binary.LittleEndian.Uint32(value[4*i:])))
+		}
+	case StorageFloat32:
+		if len(value) != 4*len(data) {
+			return ErrStorageInvalidValue
+		}
+		for i := range data {
+			data[i] = math.Float32frombits(binary.LittleEndian.Uint32(value[4*i:]))
+		}
+	case StoragePng, StorageJpeg:
+		decode := png.Decode
+		if storageType == StorageJpeg {
+			decode = jpeg.Decode
+		}
+		img, err := decode(bytes.NewReader(value))
+		if err != nil {
+			return err
+		}
+		return imageToValues(img, dim, data)
+	default:
+		return ErrStorageUnknownType
+	}
+	return nil
+}
+
+// EncodeValue encodes the data of one input of dim, which has a batch of 1,
+// for storage. Images take values in [0, 255] in (channel, height, width)
+// order, with 1, 3 or 4 channels.
+func EncodeValue(storageType string, data []float32, dim *BlobPoint) ([]byte, error) {
+	switch storageType {
+	case StorageByte, StorageUint8:
+		value := make([]byte, len(data))
+		for i, v := range data {
+			value[i] = clampByte(v)
+		}
+		return value, nil
+	case StorageInt32:
+		value := make([]byte, 4*len(data))
+		for i, v := range data {
+			binary.LittleEndian.PutUint32(value[4*i:], uint32(int32(v)))
+		}
+		return value, nil
+	case StorageFloat32:
+		value := make([]byte, 4*len(data))
+		for i, v := range data {
+			binary.LittleEndian.PutUint32(value[4*i:], math.Float32bits(v))
+		}
+		return value, nil
+	case StoragePng, StorageJpeg:
+		img, err := valuesToImage(data, dim)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if storageType == StorageJpeg {
+			err = jpeg.Encode(&buf, img, nil)
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		return buf.Bytes(), err
+	}
+	return nil, ErrStorageUnknownType
+}
+
+func imageToValues(img image.Image, dim *BlobPoint, data []float32) error {
+	bounds := img.Bounds()
+	if bounds.Dx() != dim.Width || bounds.Dy() != dim.Height {
+		return ErrStorageInvalidValue
+	}
+	return ImageToValues(img, dim, data)
+}
+
+// ImageToValues writes img resized to dim, which has a batch of 1, into data
+// in (channel, height, width) order with values in [0, 255], gray for 1
+// channel, RGB for 3 and RGBA for 4. It resizes with bilinear interpolation.
+func ImageToValues(img image.Image, dim *BlobPoint, data []float32) error {
+	if dim.Channel != 1 && dim.Channel != 3 && dim.Channel != 4 {
+		return ErrStorageInvalidValue
+	}
+	bounds := img.Bounds()
+	height, width := bounds.Dy(), bounds.Dx()
+	pixels := data
+	if height != dim.Height || width != dim.Width {
+		pixels = make([]float32, dim.Channel*height*width)
+	}
+
+	planeSize := height * width
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			if dim.Channel == 1 {
+				pixels[i] = float32(color.GrayModel.Convert(c).(color.Gray).Y)
+				continue
+			}
+			rgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+			pixels[i] = float32(rgba.R)
+			pixels[planeSize+i] = float32(rgba.G)
+			pixels[2*planeSize+i] = float32(rgba.B)
+			if dim.Channel == 4 {
+				pixels[3*planeSize+i] = float32(rgba.A)
+			}
+		}
+	}
+
+	if height != dim.Height || width != dim.Width {
+		resizeBilinear(pixels, dim.Channel, height, width, data, dim.Height, dim.Width)
+	}
+	return nil
+}
+
+// resizeBilinear resizes the (channels, height, width) input to output of
+// (channels, outputHeight, outputWidth), aligning pixel centers.
+func resizeBilinear(input []float32, channels, height, width int, output []float32, outputHeight, outputWidth int) {
+	scaleY := float32(height) / float32(outputHeight)
+	scaleX := float32(width) / float32(outputWidth)
+	for y := 0; y < outputHeight; y++ {
+		inputY := Max32(0, (float32(y)+0.5)*scaleY-0.5)
+		y0 := int(inputY)
+		y1 := y0 + 1
+		if y1 >= height {
+			y1 = height - 1
+		}
+		fy := inputY - float32(y0)
+		for x := 0; x < outputWidth; x++ {
+			inputX := Max32(0, (float32(x)+0.5)*scaleX-0.5)
+			x0 := int(inputX)
+			x1 := x0 + 1
+			if x1 >= width {
+				x1 = width - 1
+			}
+			fx := inputX - float32(x0)
+			for c := 0; c < channels; c++ {
+				plane := input[c*height*width:]
+				top := plane[y0*width+x0]*(1-fx) + plane[y0*width+x1]*fx
+				bottom := plane[y1*width+x0]*(1-fx) + plane[y1*width+x1]*fx
+				output[(c*outputHeight+y)*outputWidth+x] = top*(1-fy) + bottom*fy
+			}
+		}
+	}
+}
+
+func valuesToImage(data []float32, dim *BlobPoint) (image.Image, error) {
+	planeSize := dim.Height * dim.Width
+	if len(data) != dim.Channel*planeSize {
+		return nil, ErrStorageInvalidValue
+	}
+	rect := image.Rect(0, 0, dim.Width, dim.Height)
+	switch dim.Channel {
+	case 1:
+		img := image.NewGray(rect)
+		for i := 0; i < planeSize; i++ {
+			img.Pix[i] = clampByte(data[i])
+		}
+		return img, nil
+	case 3, 4:
+		img := image.NewNRGBA(rect)
+		for i := 0; i < planeSize; i++ {
+			img.Pix[4*i] = clampByte(data[i])
+			img.Pix[4*i+1] = clampByte(data[planeSize+i])
+			img.Pix[4*i+2] = clampByte(data[2*planeSize+i])
+			img.Pix[4*i+3] = 255
+			if dim.Channel == 4 {
+				img.Pix[4*i+3] = clampByte(data[3*planeSize+i])
+			}
+		}
+		return img, nil
+	}
+	return nil, ErrStorageInvalidValue
+}
+
+func clampByte(v float32) byte {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return byte(v + 0.5)
+}
@@ -1,10 +1,10 @@
 #!/usr/bin/env sh
-# This scripts downloads the mnist data and unzips it.
+# This scripts converts the mnist data into godnn databases.
 
 echo "Creating Training Database"
-go run create_db.go train-images-idx3-ubyte train-labels-idx1-ubyte train.db
+go run ../../cmd/godnn-convert idx -out train.db images=train-images-idx3-ubyte labels=train-labels-idx1-ubyte
 
 echo "Creating Test Database"
-go run create_db.go t10k-images-idx3-ubyte t10k-labels-idx1-ubyte t10k.db
+go run ../../cmd/godnn-convert idx -out t10k.db images=t10k-images-idx3-ubyte labels=t10k-labels-idx1-ubyte
 
-echo "Done."
\ No newline at end of file
+echo "Done."
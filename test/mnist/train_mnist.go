@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/flammit/godnn"
+	"github.com/flammit/godnn/eval"
 	"log"
 )
 
@@ -9,9 +10,9 @@ func CommonMnistLayers() []godnn.Layer {
 	return []godnn.Layer{
 		&godnn.ConvolutionLayer{
 			BaseLayer: godnn.BaseLayer{
-				"conv1",
-				[]string{"images"},
-				[]string{"conv1"},
+				Name:        "conv1",
+				BottomNames: []string{"images"},
+				TopNames:    []string{"conv1"},
 			},
 			NumOutputs:   20,
 			NumGroups:    1,
@@ -25,9 +26,9 @@ func CommonMnistLayers() []godnn.Layer {
 		},
 		&godnn.PoolingLayer{
 			BaseLayer: godnn.BaseLayer{
-				"pool1",
-				[]string{"conv1"},
-				[]string{"pool1", "pool1_mask"},
+				Name:        "pool1",
+				BottomNames: []string{"conv1"},
+				TopNames:    []string{"pool1", "pool1_mask"},
 			},
 			Method:       godnn.PoolMethodMax,
 			KernelHeight: 2,
@@ -39,9 +40,9 @@ func CommonMnistLayers() []godnn.Layer {
 		},
 		&godnn.ConvolutionLayer{
 			BaseLayer: godnn.BaseLayer{
-				"conv2",
-				[]string{"pool1"},
-				[]string{"conv2"},
+				Name:        "conv2",
+				BottomNames: []string{"pool1"},
+				TopNames:    []string{"conv2"},
 			},
 			NumOutputs:   50,
 			NumGroups:    1,
@@ -55,9 +56,9 @@ func CommonMnistLayers() []godnn.Layer {
 		},
 		&godnn.PoolingLayer{
 			BaseLayer: godnn.BaseLayer{
-				"pool2",
-				[]string{"conv2"},
-				[]string{"pool2", "pool2_mask"},
+				Name:        "pool2",
+				BottomNames: []string{"conv2"},
+				TopNames:    []string{"pool2", "pool2_mask"},
 			},
 			Method:       godnn.PoolMethodMax,
 			KernelHeight: 2,
@@ -69,35 +70,35 @@ func CommonMnistLayers() []godnn.Layer {
 		},
 		&godnn.FullyConnectedLayer{
 			BaseLayer: godnn.BaseLayer{
-				"ip1",
-				[]string{"pool2"},
-				[]string{"ip1"},
+				Name:        "ip1",
+				BottomNames: []string{"pool2"},
+				TopNames:    []string{"ip1"},
 			},
 			NumOutputs:  500,
 			IncludeBias: true,
 		},
 		&godnn.ReLULayer{
 			BaseLayer: godnn.BaseLayer{
-				"ip1_relu",
-				[]string{"ip1"},
-				[]string{"ip1_relu"},
+				Name:        "ip1_relu",
+				BottomNames: []string{"ip1"},
+				TopNames:    []string{"ip1_relu"},
 			},
 			NegativeSlope: float32(0),
 		},
 		&godnn.FullyConnectedLayer{
 			BaseLayer: godnn.BaseLayer{
-				"ip2",
-				[]string{"ip1_relu"},
-				[]string{"ip2"},
+				Name:        "ip2",
+				BottomNames: []string{"ip1_relu"},
+				TopNames:    []string{"ip2"},
 			},
 			NumOutputs:  10,
 			IncludeBias: true,
 		},
 		&godnn.SoftmaxWithLossLayer{
 			BaseLayer: godnn.BaseLayer{
-				"loss",
-				[]string{"ip2", "labels"},
-				[]string{"loss", "prob"},
+				Name:        "loss",
+				BottomNames: []string{"ip2", "labels"},
+				TopNames:    []string{"loss", "prob"},
 			},
 		},
 	}
@@ -105,15 +106,17 @@ func CommonMnistLayers() []godnn.Layer {
 
 func TrainMnistNetwork() *godnn.Network {
 	layers := []godnn.Layer{
-		&godnn.BoltDbDataLayer{
+		godnn.NewPrefetchDataLayer(&godnn.BoltDbDataLayer{
 			BaseLayer: godnn.BaseLayer{
-				"data",
-				[]string{},
-				[]string{"images", "labels"},
+				Name:        "data",
+				BottomNames: []string{},
+				TopNames:    []string{"images", "labels"},
 			},
-			DbFileName: "train.db",
-			NumInBatch: 64,
-		},
+			DbFileName:  "train.db",
+			NumInBatch:  64,
+			Shuffle:     true,
+			Transformer: &godnn.DataTransformer{Scale: 1.0 / 256},
+		}, 2, 4),
 	}
 	layers = append(layers, CommonMnistLayers()...)
 	net, err := godnn.NewNetwork(layers)
@@ -127,15 +130,25 @@ func TestMnistNetwork(trainNet *godnn.Network) *godnn.Network {
 	layers := []godnn.Layer{
 		&godnn.BoltDbDataLayer{
 			BaseLayer: godnn.BaseLayer{
-				"data",
-				[]string{},
-				[]string{"images", "labels"},
+				Name:        "data",
+				BottomNames: []string{},
+				TopNames:    []string{"images", "labels"},
 			},
-			DbFileName: "t10k.db",
-			NumInBatch: 64,
+			DbFileName:  "t10k.db",
+			NumInBatch:  64,
+			LastBatch:   godnn.LastBatchPad,
+			Transformer: &godnn.DataTransformer{Scale: 1.0 / 256, Phase: godnn.PhaseTest},
 		},
 	}
 	layers = append(layers, CommonMnistLayers()...)
+	layers = append(layers, &godnn.AccuracyLayer{
+		BaseLayer: godnn.BaseLayer{
+			Name:        "accuracy",
+			BottomNames: []string{"ip2", "labels"},
+			TopNames:    []string{"accuracy"},
+		},
+		TopK: 1,
+	})
 	net, err := godnn.NewNetworkFromTraining(layers, trainNet)
 	if err != nil {
 		log.Fatalln("failed to create test network: ", err)
@@ -160,22 +173,33 @@ func PrintNetwork(net *godnn.Network) {
 
 func main() {
 	trainNet := TrainMnistNetwork()
-	trainNet.UpdateParams = true
+	defer trainNet.Close()
 	solver := godnn.NewSgdSolver(trainNet)
 	PrintNetwork(trainNet)
 
 	// TODO: need to support batch reshape
 	testNet := TestMnistNetwork(trainNet)
+	defer testNet.Close()
 	testNet.UpdateParams = false
-	for j := 0; j < 5; j++ {
-		for i := 0; i < 100; i++ {
-			loss := trainNet.ForwardBackward()
-			log.Printf("Training ForwardBackward %d: %f\n", i, loss)
-			solver.ComputeUpdates()
-			trainNet.Update()
-		}
 
-		loss := testNet.ForwardBackward()
-		log.Printf("Test ForwardBackward %d: %f\n", j, loss)
+	trainer := godnn.NewTrainer(trainNet, testNet, solver, 500)
+	trainer.DisplayInterval = 10
+	trainer.AverageLoss = 10
+	trainer.TestInterval = 100
+	trainer.TestIterations = 10
+	trainer.SnapshotPrefix = "mnist"
+	_, err := trainer.Run()
+	if err != nil {
+		log.Fatalln("failed to train network: ", err)
+	}
+
+	report, err := eval.Evaluate(testNet, eval.Config{ScoreBlobName: "prob", LabelBlobName: "labels"})
+	if err != nil {
+		log.Fatalln("failed to evaluate test network: ", err)
+	}
+	log.Printf("Test accuracy %f, macro F1 %f, macro ROC-AUC %f over %d samples\n",
+		report.Accuracy, report.MacroF1, report.MacroRocAuc, report.NumSamples)
+	for k := range report.F1 {
+		log.Printf("Class %d: precision %f, recall %f, F1 %f\n", k, report.Precision[k], report.Recall[k], report.F1[k])
 	}
 }
@@ -22,9 +22,8 @@ func TestRandomData(n int) godnn.DataLayer {
 
 	dataLayer := &godnn.FixedDataLayer{
 		BaseLayer: godnn.BaseLayer{
-			"random",
-			nil,
-			[]string{"data", "label"},
+			Name:     "random",
+			TopNames: []string{"data", "label"},
 		},
 		DataDims: []*godnn.BlobPoint{
 			&godnn.BlobPoint{1, 1, 2, 2},
@@ -41,18 +40,18 @@ func main() {
 		TestRandomData(trainSize),
 		&godnn.FullyConnectedLayer{
 			BaseLayer: godnn.BaseLayer{
-				"ip",
-				[]string{"data"},
-				[]string{"ip"},
+				Name:        "ip",
+				BottomNames: []string{"data"},
+				TopNames:    []string{"ip"},
 			},
 			NumOutputs:  2,
 			IncludeBias: false,
 		},
 		&godnn.SoftmaxWithLossLayer{
 			BaseLayer: godnn.BaseLayer{
-				"loss",
-				[]string{"ip", "label"},
-				[]string{"loss", "prob"},
+				Name:        "loss",
+				BottomNames: []string{"ip", "label"},
+				TopNames:    []string{"loss", "prob"},
 			},
 		},
 	}
@@ -62,7 +61,7 @@ func main() {
 	}
 	log.Printf("Net Layers: %#v\n", net.Layers)
 	for i, layer := range net.Layers {
-		layerData := net.LayerDatas[i]
+		layerData := net.LayerData(layer)
 		log.Printf("Layer %d: %s\n", i, layer)
 		for j, bottomBlob := range layerData.Bottom {
 			log.Printf("Bottom Blob %d: %s\n", j, bottomBlob)
@@ -74,11 +73,10 @@ func main() {
 	log.Printf("Net Params: %#v\n", net.Params)
 
 	solver := godnn.NewSgdSolver(net)
-	net.UpdateParams = true
-	for i := 0; i < trainSize; i++ {
-		loss := net.ForwardBackward()
-		log.Printf("ForwardBackward %d: %f\n", i, loss)
-		solver.ComputeUpdates()
-		net.Update()
+	trainer := godnn.NewTrainer(net, nil, solver, trainSize)
+	trainer.DisplayInterval = 1
+	_, err = trainer.Run()
+	if err != nil {
+		log.Fatalln("failed to train network:", err)
 	}
 }
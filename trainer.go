@@ -0,0 +1,373 @@
+package godnn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+var (
+	ErrTrainerInvalidConfig = errors.New("invalid trainer: needs a train network, a solver and max iterations or epochs")
+	ErrTrainerNoDataLayer   = errors.New("invalid trainer: max epochs needs a train network with a data layer")
+	ErrTrainerUnknownMetric = errors.New("invalid early stopping: metric is not a test network output")
+)
+
+// EarlyStopping stops training when the Metric output of the test network
+// has not improved by more than MinDelta over Patience test passes.
+type EarlyStopping struct {
+	Metric   string
+	Maximize bool
+	Patience int
+	MinDelta float32
+}
+
+func (e *EarlyStopping) improves(value, best float32) bool {
+	if e.Maximize {
+		return value > best+e.MinDelta
+	}
+	return value < best-e.MinDelta
+}
+
+// IterationRecord is the loss of one training iteration, and the mean loss
+// over the last AverageLoss iterations. Epoch is the number of epochs of the
+// train data completed after the iteration.
+type IterationRecord struct {
+	Iteration    int
+	Epoch        int
+	Loss         float32
+	SmoothedLoss float32
+}
+
+// TestRecord is the mean of each test network output over a test pass.
+type TestRecord struct {
+	Iteration int
+	Outputs   map[string]float32
+}
+
+// TrainingHistory records a call to Trainer.Run.
+type TrainingHistory struct {
+	Iterations    []IterationRecord
+	Tests         []TestRecord
+	Snapshots     []string
+	BestIteration int
+	BestValue     float32
+	StoppedEarly  bool
+}
+
+// Trainer runs the solver on the train network for MaxIterations, or until the
+// data layer of the train network has completed MaxEpochs epochs. Every
+// TestInterval iterations it averages the outputs of TestIterations forward
+// passes of the test network, every DisplayInterval iterations it logs the
+// loss, and every SnapshotInterval iterations it saves the train network to
+// a file named after SnapshotPrefix. Intervals of 0 are disabled. Each
+// iteration accumulates the gradients of IterSize forward and backward passes
+// before one update, for batches larger than fit in memory at once. Training
+// also stops when the data layer of the train network is a StreamDataLayer
+// whose stream ends, or when a data layer fails to read its inputs.
+type Trainer struct {
+	TrainNet         *Network
+	TestNet          *Network
+	Solver           Solver
+	MaxIterations    int
+	MaxEpochs        int
+	IterSize         int
+	TestInterval     int
+	TestIterations   int
+	DisplayInterval  int
+	AverageLoss      int
+	SnapshotInterval int
+	SnapshotPrefix   string
+	EarlyStopping    *EarlyStopping
+
+	// Callbacks return false to stop training.
+	OnIteration func(t *Trainer, record IterationRecord) bool
+	OnTest      func(t *Trainer, record TestRecord) bool
+	OnSnapshot  func(t *Trainer, iteration int, fileName string)
+	OnEpoch     func(t *Trainer, epoch int) bool
+
+	iteration     int
+	epoch         int
+	epochsEnded   int
+	dataLayer     DataLayer
+	testDataLayer DataLayer
+	stream        StreamDataLayer
+	numPasses     int
+	lossWindow    []float32
+}
+
+func NewTrainer(trainNet, testNet *Network, solver Solver, maxIterations int) *Trainer {
+	return &Trainer{
+		TrainNet:      trainNet,
+		TestNet:       testNet,
+		Solver:        solver,
+		MaxIterations: maxIterations,
+		IterSize:      1,
+		AverageLoss:   1,
+	}
+}
+
+// Iteration is the number of updates applied so far.
+func (t *Trainer) Iteration() int {
+	return t.iteration
+}
+
+// Epoch is the number of epochs of the train data completed so far.
+func (t *Trainer) Epoch() int {
+	return t.epoch
+}
+
+// Run trains until MaxIterations, MaxEpochs, early stopping or a callback
+// stops it. It returns the error of a data layer that failed to read its
+// inputs, which discards the step of the train network in progress.
+func (t *Trainer) Run() (*TrainingHistory, error) {
+	if t.TrainNet == nil || t.Solver == nil || (t.MaxIterations <= 0 && t.MaxEpochs <= 0) {
+		return nil, ErrTrainerInvalidConfig
+	}
+	t.dataLayer = firstDataLayer(t.TrainNet)
+	t.testDataLayer = nil
+	if t.TestNet != nil {
+		t.testDataLayer = firstDataLayer(t.TestNet)
+	}
+	if t.MaxEpochs > 0 && t.dataLayer == nil {
+		return nil, ErrTrainerNoDataLayer
+	}
+	t.stream, _ = t.dataLayer.(StreamDataLayer)
+	if t.IterSize <= 0 {
+		t.IterSize = 1
+	}
+	if t.AverageLoss <= 0 {
+		t.AverageLoss = 1
+	}
+
+	h := new(TrainingHistory)
+	sinceBest := 0
+	t.TrainNet.UpdateParams = true
+train:
+	for !t.done() {
+		loss := t.step()
+		if err := dataErr(t.dataLayer); err != nil {
+			log.Printf("Iteration %d, reading train data failed: %s\n", t.iteration, err)
+			break
+		}
+		if t.numPasses == 0 {
+			if t.stream != nil && !t.stream.StreamEnded() {
+				// the stream is late, try again
+				continue
+			}
+			log.Printf("Iteration %d, stream ended\n", t.iteration)
+			break
+		}
+		t.Solver.ComputeUpdates()
+		t.TrainNet.Update()
+		t.iteration++
+		t.epoch += t.epochsEnded
+
+		record := IterationRecord{Iteration: t.iteration, Epoch: t.epoch, Loss: loss, SmoothedLoss: t.smoothLoss(loss)}
+		h.Iterations = append(h.Iterations, record)
+		if intervalReached(t.iteration, t.DisplayInterval) {
+			t.display(record)
+		}
+		if t.OnIteration != nil && !t.OnIteration(t, record) {
+			break
+		}
+
+		if intervalReached(t.iteration, t.SnapshotInterval) {
+			err := t.snapshot(h)
+			if err != nil {
+				return h, err
+			}
+		}
+
+		if t.TestNet != nil && intervalReached(t.iteration, t.TestInterval) {
+			test, err := t.test()
+			if err != nil {
+				return h, err
+			}
+			h.Tests = append(h.Tests, test)
+			if t.EarlyStopping != nil {
+				value, ok := test.Outputs[t.EarlyStopping.Metric]
+				if !ok {
+					return h, ErrTrainerUnknownMetric
+				}
+				if len(h.Tests) == 1 || t.EarlyStopping.improves(value, h.BestValue) {
+					h.BestIteration, h.BestValue = t.iteration, value
+					sinceBest = 0
+				} else if sinceBest++; sinceBest >= t.EarlyStopping.Patience {
+					log.Printf("Iteration %d, stopping early, best %s = %f at iteration %d\n",
+						t.iteration, t.EarlyStopping.Metric, h.BestValue, h.BestIteration)
+					h.StoppedEarly = true
+				}
+			}
+			if t.OnTest != nil && !t.OnTest(t, test) {
+				break
+			}
+			if h.StoppedEarly {
+				break
+			}
+		}
+
+		for epoch := t.epoch - t.epochsEnded + 1; epoch <= t.epoch; epoch++ {
+			log.Printf("Iteration %d, epoch %d done\n", t.iteration, epoch)
+			if t.OnEpoch != nil && !t.OnEpoch(t, epoch) {
+				break train
+			}
+		}
+		if t.stream != nil && t.stream.StreamEnded() {
+			log.Printf("Iteration %d, stream ended\n", t.iteration)
+			break
+		}
+	}
+
+	// always keep the final weights
+	if t.SnapshotPrefix != "" && !intervalReached(t.iteration, t.SnapshotInterval) {
+		err := t.snapshot(h)
+		if err != nil {
+			return h, err
+		}
+	}
+	return h, dataErr(t.dataLayer)
+}
+
+func firstDataLayer(net *Network) DataLayer {
+	for _, layer := range net.Layers {
+		if dataLayer, ok := layer.(DataLayer); ok {
+			return dataLayer
+		}
+	}
+	return nil
+}
+
+// dataEpoch is the epoch of a data layer, which may be nil.
+func dataEpoch(dataLayer DataLayer) int {
+	if dataLayer == nil {
+		return 0
+	}
+	return dataLayer.Epoch()
+}
+
+// dataErr is the read error of a data layer, which may be nil.
+func dataErr(dataLayer DataLayer) error {
+	if dataLayer == nil {
+		return nil
+	}
+	return dataLayer.Err()
+}
+
+// step accumulates the mean gradient of IterSize passes and returns their
+// mean loss, counting the epochs the passes complete. A pass that reads no
+// inputs from a StreamDataLayer, because the stream ended or is late, does not
+// count, and ends the step.
+func (t *Trainer) step() float32 {
+	t.TrainNet.ClearParamDiffs()
+	t.epochsEnded = 0
+	t.numPasses = 0
+	loss := float32(0)
+	for i := 0; i < t.IterSize; i++ {
+		if t.stream != nil && t.stream.StreamEnded() {
+			break
+		}
+		epoch := dataEpoch(t.dataLayer)
+		passLoss := t.TrainNet.Forward()
+		if dataErr(t.dataLayer) != nil {
+			break
+		}
+		if t.stream != nil && t.stream.NumInEpoch() == 0 {
+			break
+		}
+		t.TrainNet.Backward(passLoss)
+		loss += passLoss
+		t.numPasses++
+		// a batch larger than the inputs can complete several epochs
+		t.epochsEnded += dataEpoch(t.dataLayer) - epoch
+	}
+	if t.numPasses > 1 {
+		t.TrainNet.ScaleParamDiffs(1 / float32(t.numPasses))
+	}
+	if t.numPasses == 0 {
+		return 0
+	}
+	return loss / float32(t.numPasses)
+}
+
+func (t *Trainer) done() bool {
+	if t.MaxIterations > 0 && t.iteration >= t.MaxIterations {
+		return true
+	}
+	return t.MaxEpochs > 0 && t.epoch >= t.MaxEpochs
+}
+
+func intervalReached(iteration, interval int) bool {
+	return interval > 0 && iteration%interval == 0
+}
+
+// smoothLoss returns the mean of the last AverageLoss losses.
+func (t *Trainer) smoothLoss(loss float32) float32 {
+	t.lossWindow = append(t.lossWindow, loss)
+	if len(t.lossWindow) > t.AverageLoss {
+		t.lossWindow = t.lossWindow[len(t.lossWindow)-t.AverageLoss:]
+	}
+	sum := float32(0)
+	for _, l := range t.lossWindow {
+		sum += l
+	}
+	return sum / float32(len(t.lossWindow))
+}
+
+func (t *Trainer) display(record IterationRecord) {
+	log.Printf("Iteration %d, loss = %f\n", record.Iteration, record.SmoothedLoss)
+	losses := t.TrainNet.LossesByName()
+	for _, name := range sortedNames(losses) {
+		log.Printf("    Train net output %s = %f\n", name, losses[name])
+	}
+}
+
+func (t *Trainer) test() (TestRecord, error) {
+	record := TestRecord{Iteration: t.iteration, Outputs: make(map[string]float32)}
+	iterations := t.TestIterations
+	if iterations <= 0 {
+		iterations = 1
+	}
+	for i := 0; i < iterations; i++ {
+		t.TestNet.Forward()
+		if err := dataErr(t.testDataLayer); err != nil {
+			return record, err
+		}
+		for name, value := range t.TestNet.OutputsByName() {
+			record.Outputs[name] += value
+		}
+	}
+
+	log.Printf("Iteration %d, testing net\n", t.iteration)
+	for _, name := range sortedNames(record.Outputs) {
+		record.Outputs[name] /= float32(iterations)
+		log.Printf("    Test net output %s = %f\n", name, record.Outputs[name])
+	}
+	return record, nil
+}
+
+func (t *Trainer) snapshot(h *TrainingHistory) error {
+	if t.SnapshotPrefix == "" {
+		return nil
+	}
+	fileName := fmt.Sprintf("%s_iter_%d.snapshot", t.SnapshotPrefix, t.iteration)
+	log.Printf("Snapshotting to %s\n", fileName)
+	err := t.TrainNet.SaveFile(fileName)
+	if err != nil {
+		return err
+	}
+	h.Snapshots = append(h.Snapshots, fileName)
+	if t.OnSnapshot != nil {
+		t.OnSnapshot(t, t.iteration, fileName)
+	}
+	return nil
+}
+
+func sortedNames(values map[string]float32) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
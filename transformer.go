@@ -0,0 +1,177 @@
+package godnn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+)
+
+var (
+	ErrDataTransformerInvalidCrop = errors.New("invalid data transformer: crop larger than the input")
+	ErrDataTransformerInvalidMean = errors.New("invalid data transformer: mean does not match the input")
+	ErrDataTransformerInvalidTops = errors.New("invalid data transformer: transformed tops differ in dimensions")
+)
+
+// DataTransformer preprocesses the inputs of a data layer. In train phase it
+// takes a random CropHeight x CropWidth crop, mirrors horizontally with
+// probability 0.5 when Mirror is set, and jitters colors by adding a random
+// brightness in [-Brightness, Brightness] and scaling by a random contrast in
+// [1-Contrast, 1+Contrast]. In test phase it takes a center crop. It then
+// subtracts the mean, either MeanValues per channel (one value applies to
+// every channel) or the mean image in MeanFile, and multiplies by Scale.
+// Tops names the tops it applies to, by default the first.
+type DataTransformer struct {
+	Scale      float32
+	MeanValues []float32
+	MeanFile   string
+	CropHeight int
+	CropWidth  int
+	Mirror     bool
+	Brightness float32
+	Contrast   float32
+	Phase      Phase
+	Tops       []string
+
+	inputDim  BlobPoint
+	outputDim BlobPoint
+	mean      []float32
+}
+
+// ItemTransform holds the random choices of the transformation of one batch
+// item, drawn ahead of reading it so that concurrent reads stay reproducible.
+type ItemTransform struct {
+	OffsetHeight int
+	OffsetWidth  int
+	Mirror       bool
+	Brightness   float32
+	Contrast     float32
+}
+
+// Setup prepares the transformation of inputs of inputDim, which has a batch
+// of 1, and returns the dimensions of a transformed input.
+func (t *DataTransformer) Setup(inputDim BlobPoint) (BlobPoint, error) {
+	t.inputDim = inputDim
+	if t.Scale == 0 {
+		t.Scale = 1
+	}
+
+	outputDim := inputDim
+	if t.CropHeight > 0 {
+		outputDim.Height = t.CropHeight
+	}
+	if t.CropWidth > 0 {
+		outputDim.Width = t.CropWidth
+	}
+	if outputDim.Height > inputDim.Height || outputDim.Width > inputDim.Width {
+		return outputDim, ErrDataTransformerInvalidCrop
+	}
+	t.outputDim = outputDim
+
+	t.mean = nil
+	if t.MeanFile != "" {
+		mean, err := LoadMeanImage(t.MeanFile)
+		if err != nil {
+			return outputDim, err
+		}
+		if len(mean) != inputDim.BatchSize() {
+			return outputDim, ErrDataTransformerInvalidMean
+		}
+		t.mean = mean
+	} else if len(t.MeanValues) > 1 && len(t.MeanValues) != inputDim.Channel {
+		return outputDim, ErrDataTransformerInvalidMean
+	}
+	return outputDim, nil
+}
+
+// Transforms reports whether the transformer applies to the top at topIndex.
+func (t *DataTransformer) Transforms(topNames []string, topIndex int) bool {
+	if len(t.Tops) == 0 {
+		return topIndex == 0
+	}
+	for _, name := range t.Tops {
+		if name == topNames[topIndex] {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan draws the random choices for one batch item.
+func (t *DataTransformer) Plan(r *RandomSource) ItemTransform {
+	p := ItemTransform{Contrast: 1}
+	outputHeight, outputWidth := t.outputDim.Height, t.outputDim.Width
+	if t.Phase != PhaseTrain {
+		p.OffsetHeight = (t.inputDim.Height - outputHeight) / 2
+		p.OffsetWidth = (t.inputDim.Width - outputWidth) / 2
+		return p
+	}
+
+	p.OffsetHeight = r.Intn(t.inputDim.Height - outputHeight + 1)
+	p.OffsetWidth = r.Intn(t.inputDim.Width - outputWidth + 1)
+	p.Mirror = t.Mirror && r.Intn(2) == 1
+	if t.Brightness > 0 {
+		p.Brightness = (r.Float32()*2 - 1) * t.Brightness
+	}
+	if t.Contrast > 0 {
+		p.Contrast = 1 + (r.Float32()*2-1)*t.Contrast
+	}
+	return p
+}
+
+// Transform writes the transformation of one input to output.
+func (t *DataTransformer) Transform(p *ItemTransform, input, output []float32) {
+	channels, height, width := t.inputDim.Channel, t.inputDim.Height, t.inputDim.Width
+	outputHeight, outputWidth := t.outputDim.Height, t.outputDim.Width
+
+	for c := 0; c < channels; c++ {
+		meanValue := float32(0)
+		if len(t.MeanValues) == 1 {
+			meanValue = t.MeanValues[0]
+		} else if len(t.MeanValues) > 1 {
+			meanValue = t.MeanValues[c]
+		}
+		for h := 0; h < outputHeight; h++ {
+			for w := 0; w < outputWidth; w++ {
+				inputW := p.OffsetWidth + w
+				if p.Mirror {
+					inputW = p.OffsetWidth + outputWidth - 1 - w
+				}
+				inputIndex := (c*height+p.OffsetHeight+h)*width + inputW
+				value := input[inputIndex]*p.Contrast + p.Brightness
+				if t.mean != nil {
+					value -= t.mean[inputIndex]
+				} else {
+					value -= meanValue
+				}
+				output[(c*outputHeight+h)*outputWidth+w] = value * t.Scale
+			}
+		}
+	}
+}
+
+// LoadMeanImage reads a mean image written by SaveMeanImage.
+func LoadMeanImage(fileName string) ([]float32, error) {
+	p, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(p)%4 != 0 {
+		return nil, ErrDataTransformerInvalidMean
+	}
+	mean := make([]float32, len(p)/4)
+	for i := range mean {
+		mean[i] = math.Float32frombits(binary.LittleEndian.Uint32(p[4*i:]))
+	}
+	return mean, nil
+}
+
+// SaveMeanImage writes a mean image as little-endian float32 values in
+// (channel, height, width) order.
+func SaveMeanImage(fileName string, mean []float32) error {
+	p := make([]byte, 4*len(mean))
+	for i, v := range mean {
+		binary.LittleEndian.PutUint32(p[4*i:], math.Float32bits(v))
+	}
+	return os.WriteFile(fileName, p, 0644)
+}